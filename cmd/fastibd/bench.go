@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2020.
+ * Project:qitmeer
+ * File:bench.go
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The throughput of a full export and import
+type BenchResult struct {
+	Blocks             uint32  `json:"blocks"`
+	Bytes              int64   `json:"bytes"`
+	ExportSeconds      float64 `json:"exportSeconds"`
+	ImportSeconds      float64 `json:"importSeconds"`
+	ExportBlocksPerSec float64 `json:"exportBlocksPerSec"`
+	ExportMBPerSec     float64 `json:"exportMBPerSec"`
+	ImportBlocksPerSec float64 `json:"importBlocksPerSec"`
+	ImportMBPerSec     float64 `json:"importMBPerSec"`
+}
+
+// Export all blocks to a temporary file and import them into a temporary
+// database, measuring both phases.
+func (node *Node) Bench() (*BenchResult, error) {
+	tempDir, err := ioutil.TempDir("", "fastibd-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	cfg := node.cfg
+	exportCfg := *cfg
+	exportCfg.OutputPath = tempDir
+	exportCfg.Encrypt = false
+	node.cfg = &exportCfg
+	start := time.Now()
+	err = node.Export()
+	exportDuration := time.Since(start)
+	node.cfg = cfg
+	if err != nil {
+		return nil, err
+	}
+
+	filePath, err := GetIBDFilePath(tempDir)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadFile(filePath)
+	if err == nil {
+		data, _, _, _, err = readIBDData(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("Export data is broken")
+	}
+
+	importNode := &Node{}
+	importCfg := *cfg
+	importCfg.DataDir = filepath.Join(tempDir, defaultDataDirname)
+	importCfg.InputPath = filePath
+	err = importNode.init(&importCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer importNode.exit()
+	start = time.Now()
+	err = importNode.Import()
+	importDuration := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BenchResult{
+		Blocks:        dbnamespace.ByteOrder.Uint32(data[:4]),
+		Bytes:         fi.Size(),
+		ExportSeconds: exportDuration.Seconds(),
+		ImportSeconds: importDuration.Seconds(),
+	}
+	mb := float64(result.Bytes) / (1024 * 1024)
+	if result.ExportSeconds > 0 {
+		result.ExportBlocksPerSec = float64(result.Blocks) / result.ExportSeconds
+		result.ExportMBPerSec = mb / result.ExportSeconds
+	}
+	if result.ImportSeconds > 0 {
+		result.ImportBlocksPerSec = float64(result.Blocks) / result.ImportSeconds
+		result.ImportMBPerSec = mb / result.ImportSeconds
+	}
+	return result, nil
+}
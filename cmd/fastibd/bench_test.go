@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The key of the outputs spent by the test blocks
+var testPrivKey, testPubKey = ecc.Secp256k1.PrivKeyFromBytes([]byte{
+	0x2b, 0x8c, 0x52, 0xb7, 0x7b, 0x32, 0x7c, 0x75,
+	0x5b, 0x9b, 0x37, 0x55, 0x15, 0xd3, 0xf4, 0xc8,
+	0x6d, 0xa4, 0xd3, 0x7a, 0x35, 0x1a, 0x0a, 0x91,
+	0xd4, 0xf4, 0x0c, 0x19, 0x1b, 0x1d, 0xe5, 0x01})
+
+// The number of blocks after which the outputs paid to the test key are spent
+const testSpendDepth = 20
+
+// Create a node with a private network database holding a chain of the
+// given number of blocks after genesis.
+func createTestNode(t testing.TB, blocks int) (*Node, func()) {
+	return createSpendingTestNode(t, blocks, 0)
+}
+
+// Create a node like createTestNode, the coinbase of every block also pays
+// the given number of outputs to the test key, and they are all spent with
+// signatures by a transaction of the block testSpendDepth blocks later.
+func createSpendingTestNode(t testing.TB, blocks int, outputs int) (*Node, func()) {
+	tempDir, err := ioutil.TempDir("", "fastibd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		HomeDir:    tempDir,
+		DataDir:    filepath.Join(tempDir, defaultDataDirname),
+		PrivNet:    true,
+		DbType:     defaultDbType,
+		DAGType:    defaultDAGType,
+		DisableBar: true,
+	}
+	node := &Node{}
+	if err := node.init(cfg); err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatal(err)
+	}
+	teardown := func() {
+		node.exit()
+		os.RemoveAll(tempDir)
+	}
+
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(hash.Hash160(testPubKey.SerializeCompressed())).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		teardown()
+		t.Fatal(err)
+	}
+	genesis := params.ActiveNetParams.GenesisBlock
+	parent := params.ActiveNetParams.GenesisHash
+	coinbases := []*types.Transaction{}
+	for i := 1; i <= blocks; i++ {
+		coinbase := types.NewTransaction()
+		coinbase.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(&hash.Hash{}, math.MaxUint32),
+			Sequence:    math.MaxUint32,
+			SignScript:  []byte{txscript.OP_DATA_1, byte(i)},
+		})
+		// The first output pays the subsidy, its script also tells the
+		// coinbases apart since the hash of a transaction leaves out the
+		// input scripts.
+		subsidy := node.bc.FetchSubsidyCache().CalcBlockSubsidy(int64(i))
+		coinbase.AddTxOut(types.NewTxOutput(uint64(subsidy), []byte{txscript.OP_DATA_4,
+			byte(i), byte(i >> 8), byte(i >> 16), byte(i >> 24), txscript.OP_DROP, txscript.OP_TRUE}))
+		for j := 0; j < outputs; j++ {
+			coinbase.AddTxOut(types.NewTxOutput(0, pkScript))
+		}
+		coinbases = append(coinbases, coinbase)
+		blk := &types.Block{
+			Header:       genesis.Header,
+			Parents:      []*hash.Hash{parent},
+			Transactions: []*types.Transaction{coinbase},
+		}
+		if outputs > 0 && i > testSpendDepth {
+			spent := coinbases[i-1-testSpendDepth].TxHash()
+			tx := types.NewTransaction()
+			for j := 1; j <= outputs; j++ {
+				tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&spent, uint32(j)), nil))
+			}
+			tx.AddTxOut(types.NewTxOutput(0, []byte{txscript.OP_TRUE}))
+			for j, txIn := range tx.TxIn {
+				sigScript, err := txscript.SignatureScript(tx, j, pkScript, txscript.SigHashAll, testPrivKey, true)
+				if err != nil {
+					teardown()
+					t.Fatal(err)
+				}
+				txIn.SignScript = sigScript
+			}
+			blk.Transactions = append(blk.Transactions, tx)
+		}
+		blk.Header.Timestamp = genesis.Header.Timestamp.Add(time.Duration(i) * time.Second)
+		block := types.NewBlock(blk)
+		if err := node.bc.FastAcceptBlock(block); err != nil {
+			teardown()
+			t.Fatal(err)
+		}
+		parent = block.Hash()
+	}
+	return node, teardown
+}
+
+func TestBench(t *testing.T) {
+	node, teardown := createTestNode(t, 10)
+	defer teardown()
+
+	result, err := node.Bench()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Blocks != 10 || result.Bytes <= 0 {
+		t.Fatalf("expect 10 blocks exported, but %d blocks (%d bytes)", result.Blocks, result.Bytes)
+	}
+	if result.ExportBlocksPerSec <= 0 || result.ExportMBPerSec <= 0 ||
+		result.ImportBlocksPerSec <= 0 || result.ImportMBPerSec <= 0 {
+		t.Fatalf("expect non-zero throughput: %+v", result)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	for _, name := range []string{"exportSeconds", "importSeconds", "exportBlocksPerSec", "importMBPerSec"} {
+		if _, ok := fields[name]; !ok {
+			t.Fatalf("expect %s in %s", name, data)
+		}
+	}
+}
+
+// Import 10000 blocks spending 4 signed outputs each. The signatures are
+// checked only by the import in decode-only, and ahead of it by the workers
+// otherwise unless GOMAXPROCS is 1.
+func BenchmarkImport(b *testing.B) {
+	node, teardown := createSpendingTestNode(b, 10000, 4)
+	defer teardown()
+	_, tempDir := exportTestData(b, node)
+	defer os.RemoveAll(tempDir)
+
+	run := func(b *testing.B, workers int, checkAhead bool) {
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			importNode, importTeardown := createTestNode(b, 0)
+			importNode.cfg.InputPath = tempDir
+			importNode.cfg.Workers = workers
+			if !checkAhead {
+				importNode.sigCache = nil
+			}
+			b.StartTimer()
+			err := importNode.Import()
+			b.StopTimer()
+			importTeardown()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("decode-only", func(b *testing.B) { run(b, 1, false) })
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) { run(b, workers, true) })
+	}
+}
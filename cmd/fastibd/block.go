@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
 	"github.com/Qitmeer/qitmeer/core/types"
+	"hash/crc32"
 	"io"
 )
 
@@ -10,12 +12,30 @@ type IBDBlock struct {
 	length uint32
 	bytes  []byte
 	blk    *types.SerializedBlock
+	// Whether the bytes are preceded by their CRC32
+	checksum bool
+}
+
+// The size of length and checksum before the bytes
+func (b *IBDBlock) headSize() int {
+	if b.checksum {
+		return 8
+	}
+	return 4
+}
+
+// The size of encoded block
+func (b *IBDBlock) size() int {
+	return b.headSize() + int(b.length)
 }
 
 func (b *IBDBlock) Encode(w io.Writer) error {
-	var serializedLen [4]byte
-	dbnamespace.ByteOrder.PutUint32(serializedLen[:], b.length)
-	_, err := w.Write(serializedLen[:])
+	head := make([]byte, b.headSize())
+	dbnamespace.ByteOrder.PutUint32(head[:4], b.length)
+	if b.checksum {
+		dbnamespace.ByteOrder.PutUint32(head[4:], crc32.ChecksumIEEE(b.bytes))
+	}
+	_, err := w.Write(head)
 	if err != nil {
 		return err
 	}
@@ -23,10 +43,40 @@ func (b *IBDBlock) Encode(w io.Writer) error {
 	return err
 }
 
+// Decode the block at the given order from the beginning of data, the size of
+// the encoded block is returned along with it. The size is zero if the block
+// is truncated, then the blocks after it can't be found.
+func decodeBlock(data []byte, order uint32, checksum bool) (*types.SerializedBlock, int, error) {
+	ibdb := &IBDBlock{checksum: checksum}
+	err := ibdb.Decode(data)
+	if err != nil {
+		size := 0
+		if len(data) >= ibdb.headSize() && ibdb.size() <= len(data) {
+			size = ibdb.size()
+		}
+		return nil, size, fmt.Errorf("Block (%d) is broken: %s", order, err)
+	}
+	return ibdb.blk, ibdb.size(), nil
+}
+
 func (b *IBDBlock) Decode(bytes []byte) error {
+	headSize := b.headSize()
+	if len(bytes) < headSize {
+		return fmt.Errorf("The length of block is missing")
+	}
 	b.length = dbnamespace.ByteOrder.Uint32(bytes[:4])
+	if uint64(len(bytes)) < uint64(b.length)+uint64(headSize) {
+		return fmt.Errorf("The block is truncated: %d of %d bytes", len(bytes)-headSize, b.length)
+	}
+	b.bytes = bytes[headSize : uint64(headSize)+uint64(b.length)]
+	if b.checksum {
+		expect := dbnamespace.ByteOrder.Uint32(bytes[4:8])
+		if sum := crc32.ChecksumIEEE(b.bytes); sum != expect {
+			return fmt.Errorf("The checksum %08x doesn't match %08x", sum, expect)
+		}
+	}
 
-	block, err := types.NewBlockFromBytes(bytes[4 : b.length+4])
+	block, err := types.NewBlockFromBytes(b.bytes)
 	if err != nil {
 		return err
 	}
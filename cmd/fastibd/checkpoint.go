@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2020.
+ * Project:qitmeer
+ * File:checkpoint.go
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// The number of blocks exported between two checkpoints
+const checkpointInterval = 1000
+
+// The progress of export, it is saved beside the output data so that an
+// interrupted export can be resumed.
+type exportCheckpoint struct {
+	Version  byte   `json:"version"`
+	From     uint   `json:"from"`
+	EndNum   uint   `json:"endNum"`
+	ByID     bool   `json:"byID"`
+	Next     uint   `json:"next"`
+	Offset   int64  `json:"offset"`
+	LastHash string `json:"lastHash"`
+}
+
+func getCheckpointPath(dataPath string) string {
+	return dataPath + ".checkpoint"
+}
+
+// Load the checkpoint, there is no checkpoint if the file doesn't exist.
+func loadCheckpoint(path string) (*exportCheckpoint, error) {
+	if !Exists(path) {
+		return nil, nil
+	}
+	data, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cp := &exportCheckpoint{}
+	err = json.Unmarshal(data, cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// Save the checkpoint through a temporary file, so an interruption never
+// leaves a broken checkpoint.
+func (cp *exportCheckpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	err = ioutil.WriteFile(tempPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2020.
+ * Project:qitmeer
+ * File:compress.go
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+
+	// Every block is preceded by the CRC32 of its bytes since version 2.
+	// The header records the first exported block and the hash of the
+	// block before it since version 3.
+	ibdVersion         = 3
+	ibdChecksumVersion = 2
+	ibdRangeVersion    = 3
+)
+
+// The head of exported data: magic | version | compression | from | prev, the
+// data exported before it only has the number of blocks at the beginning. From
+// is the first exported block and prev is the hash of the block before it, the
+// data before version 3 always starts from the first block after genesis.
+var ibdMagic = []byte("QIBD")
+
+var ibdHeaderSize = len(ibdMagic) + 6 + hash.HashSize
+
+// The compressions by their index in the header
+var compressions = []string{compressNone, compressGzip, compressZstd}
+
+// Return the index of compression, no compression is used by default.
+func getCompression(name string) (byte, error) {
+	if len(name) == 0 {
+		name = compressNone
+	}
+	for i, c := range compressions {
+		if c == name {
+			return byte(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown compression: %s", name)
+}
+
+func writeIBDHeader(w io.Writer, compression byte, from uint32, prev *hash.Hash) error {
+	header := make([]byte, ibdHeaderSize)
+	copy(header, ibdMagic)
+	header[len(ibdMagic)] = ibdVersion
+	header[len(ibdMagic)+1] = compression
+	dbnamespace.ByteOrder.PutUint32(header[len(ibdMagic)+2:], from)
+	copy(header[len(ibdMagic)+6:], prev[:])
+	_, err := w.Write(header)
+	return err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (w nopWriteCloser) Close() error {
+	return nil
+}
+
+// Wrap the writer with the compressor, it must be closed to flush the data.
+func newCompressWriter(w io.Writer, compression byte) (io.WriteCloser, error) {
+	switch compressions[compression] {
+	case compressNone:
+		return nopWriteCloser{w}, nil
+	case compressGzip:
+		return gzip.NewWriter(w), nil
+	case compressZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("%s compression is not supported by this build", compressions[compression])
+}
+
+// Check the header of exported data and return the decompressed blocks with
+// the version of data, the first exported block and the hash of the block
+// before it. The version is zero if there is no header, and the hash is nil
+// if the data doesn't record it.
+func readIBDData(data []byte) ([]byte, byte, uint32, *hash.Hash, error) {
+	if !bytes.HasPrefix(data, ibdMagic) {
+		return data, 0, 1, nil, nil
+	}
+	if len(data) < len(ibdMagic)+2 {
+		return nil, 0, 0, nil, fmt.Errorf("The header of data is broken")
+	}
+	version := data[len(ibdMagic)]
+	if version == 0 || version > ibdVersion {
+		return nil, 0, 0, nil, fmt.Errorf("Unknown data version: %d", version)
+	}
+	compression := data[len(ibdMagic)+1]
+	if int(compression) >= len(compressions) {
+		return nil, 0, 0, nil, fmt.Errorf("Unknown compression: %d", compression)
+	}
+	headerSize := len(ibdMagic) + 2
+	from := uint32(1)
+	var prev *hash.Hash
+	if version >= ibdRangeVersion {
+		headerSize = ibdHeaderSize
+		if len(data) < headerSize {
+			return nil, 0, 0, nil, fmt.Errorf("The header of data is broken")
+		}
+		from = dbnamespace.ByteOrder.Uint32(data[len(ibdMagic)+2 : headerSize])
+		if from == 0 {
+			return nil, 0, 0, nil, fmt.Errorf("The data can't start from genesis")
+		}
+		prev = &hash.Hash{}
+		copy(prev[:], data[len(ibdMagic)+6:headerSize])
+	}
+	body := data[headerSize:]
+	switch compressions[compression] {
+	case compressNone:
+		return body, version, from, prev, nil
+	case compressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		defer r.Close()
+		body, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		return body, version, from, prev, nil
+	case compressZstd:
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		defer r.Close()
+		body, err = r.DecodeAll(body, nil)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		return body, version, from, prev, nil
+	}
+	return nil, 0, 0, nil, fmt.Errorf("%s compression is not supported by this build", compressions[compression])
+}
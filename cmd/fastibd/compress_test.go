@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Export the blocks of node into a temporary directory, the data without the
+// header and the directory are returned.
+func exportTestData(t testing.TB, node *Node) ([]byte, string) {
+	tempDir, err := ioutil.TempDir("", "fastibd-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.cfg.OutputPath = tempDir
+	if err := node.Export(); err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatal(err)
+	}
+	data, err := ReadFile(filepath.Join(tempDir, defaultFileName))
+	if err == nil {
+		data, _, _, _, err = readIBDData(data)
+	}
+	if err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatal(err)
+	}
+	return data, tempDir
+}
+
+// Export the blocks of node with the compression and import them into an
+// empty node, the number of imported blocks is returned.
+func exportAndImport(t *testing.T, node *Node, compress string) (uint, error) {
+	tempDir, err := ioutil.TempDir("", "fastibd-compress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	cfg := *node.cfg
+	cfg.OutputPath = tempDir
+	cfg.Compress = compress
+	node.cfg = &cfg
+	if err := node.Export(); err != nil {
+		return 0, err
+	}
+
+	importNode, teardown := createTestNode(t, 0)
+	defer teardown()
+	importNode.cfg.InputPath = tempDir
+	if err := importNode.Import(); err != nil {
+		t.Fatal(err)
+	}
+	return importNode.bc.BlockDAG().GetMainChainTip().GetOrder(), nil
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	node, teardown := createTestNode(t, 10)
+	defer teardown()
+	for _, compress := range []string{compressNone, compressGzip, compressZstd} {
+		blocks, err := exportAndImport(t, node, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocks != 10 {
+			t.Fatalf("expect 10 blocks imported with %s, but %d", compress, blocks)
+		}
+	}
+	if _, err := exportAndImport(t, node, "lz4"); err == nil || !strings.Contains(err.Error(), "Unknown compression") {
+		t.Fatalf("expect lz4 is unknown, but %v", err)
+	}
+	// The data written with an index after the known compressions is rejected.
+	header := &bytes.Buffer{}
+	if err := writeIBDHeader(header, byte(len(compressions)), 1, params.ActiveNetParams.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := readIBDData(header.Bytes()); err == nil || !strings.Contains(err.Error(), "Unknown compression") {
+		t.Fatalf("expect compression %d is unknown, but %v", len(compressions), err)
+	}
+}
+
+func TestCompressEmptyDatabase(t *testing.T) {
+	node, teardown := createTestNode(t, 0)
+	defer teardown()
+	for _, compress := range []string{compressNone, compressGzip, compressZstd} {
+		blocks, err := exportAndImport(t, node, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocks != 0 {
+			t.Fatalf("expect no block imported with %s, but %d", compress, blocks)
+		}
+	}
+}
+
+// Re-encode the exported blocks without their checksums, like the data
+// exported before version 2.
+func stripChecksums(t *testing.T, data []byte) []byte {
+	result := append([]byte{}, data[:4]...)
+	count := dbnamespace.ByteOrder.Uint32(data[:4])
+	offset := 4
+	for i := uint32(0); i < count; i++ {
+		ibdb := &IBDBlock{checksum: true}
+		if err := ibdb.Decode(data[offset:]); err != nil {
+			t.Fatal(err)
+		}
+		offset += ibdb.size()
+		ibdb.checksum = false
+		buf := &bytes.Buffer{}
+		if err := ibdb.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, buf.Bytes()...)
+	}
+	return result
+}
+
+func TestImportOldVersions(t *testing.T) {
+	node, teardown := createTestNode(t, 3)
+	defer teardown()
+	data, tempDir := exportTestData(t, node)
+	defer os.RemoveAll(tempDir)
+	legacy := stripChecksums(t, data)
+	header := &bytes.Buffer{}
+	if err := writeIBDHeader(header, 0, 1, params.ActiveNetParams.GenesisHash); err != nil {
+		t.Fatal(err)
+	}
+	version1 := append([]byte{}, header.Bytes()[:len(ibdMagic)+2]...)
+	version1[len(ibdMagic)] = 1
+	version1 = append(version1, legacy...)
+
+	// The data exported before the header was added, and the data of
+	// version 1 which has no checksums.
+	filePath := filepath.Join(tempDir, defaultFileName)
+	for _, fileData := range [][]byte{legacy, version1} {
+		if err := ioutil.WriteFile(filePath, fileData, 0644); err != nil {
+			t.Fatal(err)
+		}
+		importNode, importTeardown := createTestNode(t, 0)
+		importNode.cfg.InputPath = tempDir
+		err := importNode.Import()
+		order := importNode.bc.BlockDAG().GetMainChainTip().GetOrder()
+		importTeardown()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order != 3 {
+			t.Fatal("expect 3 blocks imported")
+		}
+	}
+}
+
+func TestImportCorruptedBlock(t *testing.T) {
+	node, teardown := createTestNode(t, 5)
+	defer teardown()
+	data, tempDir := exportTestData(t, node)
+	defer os.RemoveAll(tempDir)
+
+	// Flip a byte in the middle of the third block.
+	offset := ibdHeaderSize + 4
+	for i := 0; i < 2; i++ {
+		ibdb := &IBDBlock{checksum: true}
+		if err := ibdb.Decode(data[offset-ibdHeaderSize:]); err != nil {
+			t.Fatal(err)
+		}
+		offset += ibdb.size()
+	}
+	length := int(dbnamespace.ByteOrder.Uint32(data[offset-ibdHeaderSize:]))
+	filePath := filepath.Join(tempDir, defaultFileName)
+	fileData, err := ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileData[offset+8+length/2] ^= 0xff
+	if err := ioutil.WriteFile(filePath, fileData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	importNode, importTeardown := createTestNode(t, 0)
+	defer importTeardown()
+	importNode.cfg.InputPath = tempDir
+	err = importNode.Import()
+	if err == nil || !strings.Contains(err.Error(), "(3)") || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expect the checksum of block 3 mismatches, but %v", err)
+	}
+	if order := importNode.bc.BlockDAG().GetMainChainTip().GetOrder(); order != 2 {
+		t.Fatalf("expect the blocks before the corrupted one are imported, but %d", order)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/util"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/params"
 	"os"
 	"path/filepath"
@@ -10,7 +11,8 @@ import (
 )
 
 const (
-	defaultDataDirname = "data"
+	defaultDataDirname     = "data"
+	defaultSigCacheMaxSize = 100000
 )
 
 var (
@@ -34,7 +36,14 @@ type Config struct {
 	InputPath  string
 	DisableBar bool
 	EndPoint   string
+	From       uint
+	To         uint
 	ByID       bool
+	Encrypt    bool
+	Restart    bool
+	Compress   string
+	Workers    int
+	Continue   bool
 }
 
 func (c *Config) load() error {
@@ -102,6 +111,18 @@ func (c *Config) load() error {
 	return nil
 }
 
+// Check whether the blocks can be replayed by the DAG type. Only phantom
+// provides the main chain tip which export and import are based on.
+func checkDAGType(dagType string) error {
+	if blockdag.NewBlockDAG(dagType) == nil {
+		return fmt.Errorf("Unknown DAG type: %s", dagType)
+	}
+	if dagType != defaultDAGType {
+		return fmt.Errorf("DAG type %s is not implemented for IBD, please use %s", dagType, defaultDAGType)
+	}
+	return nil
+}
+
 func GetIBDFilePath(path string) (string, error) {
 	if len(path) <= 0 {
 		return "", fmt.Errorf("Path error")
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnknownDAGType(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fastibd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	for _, dagType := range []string{"unknown", "conflux"} {
+		cfg := &Config{
+			HomeDir:    tempDir,
+			DataDir:    filepath.Join(tempDir, defaultDataDirname),
+			PrivNet:    true,
+			DbType:     defaultDbType,
+			DAGType:    dagType,
+			InputPath:  tempDir,
+			DisableBar: true,
+		}
+		node := &Node{}
+		if err := node.init(cfg); err == nil {
+			node.exit()
+			t.Fatalf("expect import with DAG type %s fails", dagType)
+		}
+		if node.db != nil {
+			t.Fatalf("expect no database is opened with DAG type %s", dagType)
+		}
+	}
+}
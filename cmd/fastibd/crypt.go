@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2020.
+ * Project:qitmeer
+ * File:crypt.go
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+)
+
+const (
+	// The environment variable of passphrase for the encrypted data
+	passphraseEnv = "FASTIBD_PASSPHRASE"
+
+	saltSize = 16
+	keySize  = 32
+	scryptN  = 32768
+	scryptR  = 8
+	scryptP  = 1
+)
+
+// The head of encrypted data: magic | salt, it's followed by the chunks of
+// length | sealed data. Every chunk is sealed with the counter of chunks as
+// the nonce, and the head along with whether it's the last chunk is
+// authenticated, so the data can't be reordered or truncated.
+var encryptMagic = []byte("QIBDAES1")
+
+// The size of plain data sealed in a chunk
+const encryptChunkSize = 64 * 1024
+
+var ErrWrongPassphrase = fmt.Errorf("wrong passphrase or corrupted data")
+
+// Acquire the passphrase from environment, or prompt for it.
+func getPassphrase(confirm bool) ([]byte, error) {
+	if pass := os.Getenv(passphraseEnv); len(pass) > 0 {
+		return []byte(pass), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("No passphrase, please set %s", passphraseEnv)
+	}
+	fmt.Print("Passphrase:")
+	pass, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		fmt.Print("Confirm passphrase:")
+		again, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, fmt.Errorf("The passphrases do not match")
+		}
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("Passphrase is empty")
+	}
+	return pass, nil
+}
+
+func newGCM(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	head    []byte
+	counter uint64
+	buf     []byte
+}
+
+// Wrap the writer to encrypt the data with AES-GCM chunk by chunk, the key is
+// derived from passphrase. It must be closed to seal the last chunk.
+func newEncryptWriter(w io.Writer, passphrase []byte) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 0, len(encryptMagic)+saltSize)
+	head = append(head, encryptMagic...)
+	head = append(head, salt...)
+	_, err = w.Write(head)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, gcm: gcm, head: head, buf: make([]byte, 0, encryptChunkSize)}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		size := encryptChunkSize - len(ew.buf)
+		if size > len(p) {
+			size = len(p)
+		}
+		ew.buf = append(ew.buf, p[:size]...)
+		p = p[size:]
+		if len(ew.buf) == encryptChunkSize {
+			err := ew.seal(false)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+func (ew *encryptWriter) seal(last bool) error {
+	data := ew.gcm.Seal(nil, chunkNonce(ew.gcm, ew.counter), ew.buf, chunkAD(ew.head, last))
+	var length [4]byte
+	dbnamespace.ByteOrder.PutUint32(length[:], uint32(len(data)))
+	_, err := ew.w.Write(length[:])
+	if err != nil {
+		return err
+	}
+	_, err = ew.w.Write(data)
+	if err != nil {
+		return err
+	}
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	return nil
+}
+
+// The key is unique to every data by the salt, so the counter of chunks is
+// enough for the nonce.
+func chunkNonce(gcm cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+func chunkAD(head []byte, last bool) []byte {
+	ad := append([]byte{}, head...)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// Encrypt the data with AES-GCM, the key is derived from passphrase.
+func encryptData(data []byte, passphrase []byte) ([]byte, error) {
+	var result bytes.Buffer
+	ew, err := newEncryptWriter(&result, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ew.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = ew.Close()
+	if err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptMagic)
+}
+
+// Decrypt the data that was encrypted by encryptWriter.
+func decryptData(data []byte, passphrase []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return nil, fmt.Errorf("Data is not encrypted")
+	}
+	offset := len(encryptMagic) + saltSize
+	if len(data) < offset {
+		return nil, fmt.Errorf("Encrypted data is too short")
+	}
+	head := data[:offset]
+	gcm, err := newGCM(passphrase, head[len(encryptMagic):])
+	if err != nil {
+		return nil, err
+	}
+	result := []byte{}
+	for counter := uint64(0); ; counter++ {
+		if len(data) < offset+4 {
+			return nil, fmt.Errorf("Encrypted data is too short")
+		}
+		length := int(dbnamespace.ByteOrder.Uint32(data[offset : offset+4]))
+		offset += 4
+		if len(data) < offset+length {
+			return nil, fmt.Errorf("Encrypted data is too short")
+		}
+		last := offset+length == len(data)
+		result, err = gcm.Open(result, chunkNonce(gcm, counter), data[offset:offset+length], chunkAD(head, last))
+		if err != nil {
+			return nil, ErrWrongPassphrase
+		}
+		offset += length
+		if last {
+			return result, nil
+		}
+	}
+}
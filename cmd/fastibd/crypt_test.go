@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	genesis := types.NewBlock(params.PrivNetParams.GenesisBlock)
+	blockBytes, err := genesis.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &bytes.Buffer{}
+	plain.Write([]byte{1, 0, 0, 0})
+	ibdb := &IBDBlock{length: uint32(len(blockBytes)), bytes: blockBytes}
+	if err := ibdb.Encode(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := encryptData(plain.Bytes(), []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(data) {
+		t.Fatal("expect encrypted data")
+	}
+	if bytes.Contains(data, blockBytes) {
+		t.Fatal("block data is not encrypted")
+	}
+
+	result, err := decryptData(data, []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, plain.Bytes()) {
+		t.Fatal("decrypted data is different")
+	}
+	ibdb = &IBDBlock{}
+	if err := ibdb.Decode(result[4:]); err != nil {
+		t.Fatal(err)
+	}
+	if !ibdb.blk.Hash().IsEqual(genesis.Hash()) {
+		t.Fatalf("expect block %s, but %s", genesis.Hash(), ibdb.blk.Hash())
+	}
+
+	_, err = decryptData(data, []byte("wrong"))
+	if err != ErrWrongPassphrase {
+		t.Fatalf("expect %v, but %v", ErrWrongPassphrase, err)
+	}
+}
+
+func TestEncryptChunks(t *testing.T) {
+	plain := make([]byte, encryptChunkSize*2+100)
+	for i := range plain {
+		plain[i] = byte(i)
+	}
+	data, err := encryptData(plain, []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decryptData(data, []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, plain) {
+		t.Fatal("decrypted data is different")
+	}
+
+	// Dropping the last chunk leaves a chunk that isn't sealed as the last.
+	last := len(data) - (4 + 100 + 16)
+	if _, err := decryptData(data[:last], []byte("right")); err != ErrWrongPassphrase {
+		t.Fatalf("expect the truncated data fails, but %v", err)
+	}
+	empty, err := encryptData(nil, []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := decryptData(empty, []byte("right")); err != nil || len(result) != 0 {
+		t.Fatalf("expect empty data, but %v", err)
+	}
+}
+
+func TestEncryptExport(t *testing.T) {
+	t.Setenv(passphraseEnv, "right")
+	node, teardown := createTestNode(t, 10)
+	defer teardown()
+	tempDir, err := ioutil.TempDir("", "fastibd-encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	cfg := *node.cfg
+	cfg.OutputPath = tempDir
+	cfg.Encrypt = true
+	node.cfg = &cfg
+	if err := node.Export(); err != nil {
+		t.Fatal(err)
+	}
+
+	importNode, importTeardown := createTestNode(t, 0)
+	defer importTeardown()
+	importNode.cfg.InputPath = tempDir
+	if err := importNode.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if order := importNode.bc.BlockDAG().GetMainChainTip().GetOrder(); order != 10 {
+		t.Fatalf("expect 10 blocks imported, but %d", order)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	_ "github.com/Qitmeer/qitmeer/database/ffldb"
 	_ "github.com/Qitmeer/qitmeer/services/common"
 	"github.com/urfave/cli/v2"
@@ -55,12 +57,38 @@ func fastIBD() error {
 						Usage:       "End point for output data",
 						Destination: &cfg.EndPoint,
 					},
+					&cli.UintFlag{
+						Name:        "from",
+						Usage:       "The first block to export by order, the blocks before it must be imported before the data",
+						Destination: &cfg.From,
+					},
+					&cli.UintFlag{
+						Name:        "to",
+						Usage:       "The last block to export, the main chain tip if it's beyond",
+						Destination: &cfg.To,
+					},
 					&cli.BoolFlag{
 						Name:        "byid",
 						Aliases:     []string{"i"},
 						Usage:       "Export by block id",
 						Destination: &cfg.ByID,
 					},
+					&cli.BoolFlag{
+						Name:        "encrypt",
+						Usage:       "Encrypt output data with a passphrase (" + passphraseEnv + " or prompt)",
+						Destination: &cfg.Encrypt,
+					},
+					&cli.BoolFlag{
+						Name:        "restart",
+						Usage:       "Export from the beginning instead of resuming from the checkpoint",
+						Destination: &cfg.Restart,
+					},
+					&cli.StringFlag{
+						Name:        "compress",
+						Usage:       "Compression of output data {none,gzip,zstd}",
+						Value:       compressNone,
+						Destination: &cfg.Compress,
+					},
 				},
 				Before: func(c *cli.Context) error {
 					return node.init(cfg)
@@ -86,6 +114,11 @@ func fastIBD() error {
 						Value:       defaultHomeDir,
 						Destination: &cfg.InputPath,
 					},
+					&cli.IntFlag{
+						Name:        "workers",
+						Usage:       "Number of workers decoding blocks and checking their signatures ahead of import, 0 means the number of CPUs",
+						Destination: &cfg.Workers,
+					},
 				},
 				Before: func(c *cli.Context) error {
 					return node.init(cfg)
@@ -97,6 +130,60 @@ func fastIBD() error {
 					return node.Import()
 				},
 			},
+			&cli.Command{
+				Name:        "verify",
+				Aliases:     []string{"v"},
+				Category:    "IBD",
+				Usage:       "Verify the data of blocks without importing",
+				Description: "Decode all blocks, check their parents and validate their transaction scripts and spends, the database is never touched. Only the data exported from the first block can be verified, not a range exported with --from",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "path",
+						Aliases:     []string{"p"},
+						Usage:       "Path to input data",
+						Value:       defaultHomeDir,
+						Destination: &cfg.InputPath,
+					},
+					&cli.BoolFlag{
+						Name:        "continue",
+						Usage:       "Continue after the broken blocks",
+						Destination: &cfg.Continue,
+					},
+				},
+				Before: func(c *cli.Context) error {
+					node.cfg = cfg
+					return cfg.load()
+				},
+				Action: func(c *cli.Context) error {
+					_, err := node.Verify()
+					return err
+				},
+			},
+			&cli.Command{
+				Name:        "bench",
+				Aliases:     []string{"b"},
+				Category:    "IBD",
+				Usage:       "Measure the throughput of export and import",
+				Description: "Export all blocks and import them into a temporary database, then print the throughput as JSON",
+				Before: func(c *cli.Context) error {
+					return node.init(cfg)
+				},
+				After: func(c *cli.Context) error {
+					return node.exit()
+				},
+				Action: func(c *cli.Context) error {
+					result, err := node.Bench()
+					if err != nil {
+						return err
+					}
+					data, err := json.MarshalIndent(result, "", "  ")
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(data))
+					return nil
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -140,7 +227,7 @@ func fastIBD() error {
 			&cli.StringFlag{
 				Name:        "dagtype",
 				Aliases:     []string{"G"},
-				Usage:       "DAG type {phantom,conflux,spectre}",
+				Usage:       "DAG type {phantom,conflux,spectre}, only phantom is implemented",
 				Value:       defaultDAGType,
 				Destination: &cfg.DAGType,
 			},
@@ -16,11 +16,14 @@ import (
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
 	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mining"
+	"io"
 	"os"
 	"path"
+	"runtime"
 )
 
 type Node struct {
@@ -28,6 +31,12 @@ type Node struct {
 	bc   *blockchain.BlockChain
 	db   database.DB
 	cfg  *Config
+
+	// The signatures checked ahead of import
+	sigCache *txscript.SigCache
+
+	// Stop export after the number of blocks, zero means no limit
+	exportLimit uint
 }
 
 func (node *Node) init(cfg *Config) error {
@@ -35,6 +44,10 @@ func (node *Node) init(cfg *Config) error {
 	if err != nil {
 		return err
 	}
+	err = checkDAGType(cfg.DAGType)
+	if err != nil {
+		return err
+	}
 	node.cfg = cfg
 	// Load the block database.
 	db, err := LoadBlockDB(cfg.DbType, cfg.DataDir, true)
@@ -51,6 +64,7 @@ func (node *Node) init(cfg *Config) error {
 	// index-manager
 	indexManager := index.NewManager(db, indexes, params.ActiveNetParams.Params)
 
+	node.sigCache = txscript.NewSigCache(defaultSigCacheMaxSize)
 	bc, err := blockchain.New(&blockchain.Config{
 		DB:           db,
 		ChainParams:  params.ActiveNetParams.Params,
@@ -58,6 +72,7 @@ func (node *Node) init(cfg *Config) error {
 		DAGType:      cfg.DAGType,
 		BlockVersion: mining.BlockVersion(params.ActiveNetParams.Params.Net),
 		IndexManager: indexManager,
+		SigCache:     node.sigCache,
 	})
 	if err != nil {
 		log.Error(err.Error())
@@ -87,17 +102,57 @@ func (node *Node) DB() database.DB {
 	return node.db
 }
 
+// The first block to export, the blocks after genesis are exported by default.
+func (node *Node) exportFrom() uint {
+	if node.cfg.From == 0 {
+		return 1
+	}
+	return node.cfg.From
+}
+
 func (node *Node) Export() error {
-	mainTip := node.bc.BlockDAG().GetMainChainTip()
-	if mainTip.GetOrder() <= 0 {
-		return fmt.Errorf("No blocks in database")
+	// Import checks the first block against the order of main chain tip, so
+	// the range exported by id can't be imported.
+	if node.cfg.ByID && node.exportFrom() > 1 {
+		return fmt.Errorf("The range from %d can't be exported by id, only by order", node.cfg.From)
 	}
+	mainTip := node.bc.BlockDAG().GetMainChainTip()
 	outFilePath, err := GetIBDFilePath(node.cfg.OutputPath)
 	if err != nil {
 		return err
 	}
+	compression, err := getCompression(node.cfg.Compress)
+	if err != nil {
+		return err
+	}
+	var passphrase []byte
+	if node.cfg.Encrypt {
+		passphrase, err = getPassphrase(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The encrypted and the compressed data can't be appended to, so only the
+	// plain data can be resumed.
+	resumable := !node.cfg.Encrypt && compressions[compression] == compressNone
+	checkpointPath := getCheckpointPath(outFilePath)
+	var checkpoint *exportCheckpoint
+	if node.cfg.Restart || !resumable {
+		err = os.RemoveAll(checkpointPath)
+	} else {
+		checkpoint, err = loadCheckpoint(checkpointPath)
+	}
+	if err != nil {
+		return err
+	}
 
-	outFile, err := os.OpenFile(outFilePath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	var outFile *os.File
+	if checkpoint != nil {
+		outFile, err = node.resumeExport(outFilePath, checkpoint)
+	} else {
+		outFile, err = os.OpenFile(outFilePath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	}
 	if err != nil {
 		return err
 	}
@@ -113,7 +168,9 @@ func (node *Node) Export() error {
 		endNum = mainTip.GetOrder()
 	}
 
-	if len(node.cfg.EndPoint) > 0 {
+	if checkpoint != nil {
+		endNum = checkpoint.EndNum
+	} else if len(node.cfg.EndPoint) > 0 {
 		ephash, err := hash.NewHashFromStr(node.cfg.EndPoint)
 		if err != nil {
 			return err
@@ -136,26 +193,78 @@ func (node *Node) Export() error {
 		}
 
 	}
+	from := node.exportFrom()
+	if checkpoint == nil && node.cfg.To > 0 {
+		if node.cfg.To > endNum {
+			log.Info(fmt.Sprintf("The end %d is beyond the last block, export to %d", node.cfg.To, endNum))
+		} else {
+			endNum = node.cfg.To
+		}
+	}
+	if from > endNum+1 {
+		return fmt.Errorf("There is no block from %d to %d", from, endNum)
+	}
+	count := endNum + 1 - from
+
 	var bar *ProgressBar
 	if !node.cfg.DisableBar {
 
 		bar = &ProgressBar{}
 		bar.init("Export:")
-		bar.reset(int(endNum))
+		bar.reset(int(count))
 		bar.add()
 	} else {
 		log.Info("Export...")
 	}
 
-	var maxNum [4]byte
-	dbnamespace.ByteOrder.PutUint32(maxNum[:], uint32(endNum))
-	_, err = outFile.Write(maxNum[:])
-	if err != nil {
-		return err
+	// The data stream is sealed chunk by chunk when encrypting
+	var out io.Writer = outFile
+	var ew io.WriteCloser
+	if node.cfg.Encrypt {
+		ew, err = newEncryptWriter(outFile, passphrase)
+		if err != nil {
+			return err
+		}
+		out = ew
+	}
+
+	start := from
+	var cw io.WriteCloser
+	if checkpoint != nil {
+		cw = nopWriteCloser{out}
+		start = checkpoint.Next
+		if bar != nil {
+			for i := from; i < start; i++ {
+				bar.add()
+			}
+		}
+	} else {
+		prev := node.bc.BlockDAG().GetBlockByOrder(from - 1)
+		if prev == nil {
+			return fmt.Errorf("Can't find the block %d before the data", from-1)
+		}
+		err = writeIBDHeader(out, compression, uint32(from), prev)
+		if err != nil {
+			return err
+		}
+		cw, err = newCompressWriter(out, compression)
+		if err != nil {
+			return err
+		}
+		var maxNum [4]byte
+		dbnamespace.ByteOrder.PutUint32(maxNum[:], uint32(count))
+		_, err = cw.Write(maxNum[:])
+		if err != nil {
+			return err
+		}
+		checkpoint = &exportCheckpoint{Version: ibdVersion, From: from, EndNum: endNum, ByID: node.cfg.ByID, Next: start, Offset: int64(ibdHeaderSize + 4)}
 	}
 	var i uint
 	var blockHash *hash.Hash
-	for i = uint(1); i <= endNum; i++ {
+	for i = start; i <= endNum; i++ {
+		if node.exportLimit > 0 && resumable && i-start >= node.exportLimit {
+			break
+		}
 		if node.cfg.ByID {
 			ib := node.bc.BlockDAG().GetBlockById(i)
 			if ib != nil {
@@ -179,14 +288,23 @@ func (node *Node) Export() error {
 		if err != nil {
 			return err
 		}
-		ibdb := &IBDBlock{length: uint32(len(bytes)), bytes: bytes}
-		err = ibdb.Encode(outFile)
+		ibdb := &IBDBlock{length: uint32(len(bytes)), bytes: bytes, checksum: true}
+		err = ibdb.Encode(cw)
 		if err != nil {
 			return err
 		}
 		if bar != nil {
 			bar.add()
 		}
+		checkpoint.Next = i + 1
+		checkpoint.Offset += int64(ibdb.size())
+		checkpoint.LastHash = blockHash.String()
+		if resumable && i%checkpointInterval == 0 {
+			err = checkpoint.save(checkpointPath)
+			if err != nil {
+				return err
+			}
+		}
 
 		/*if endPoint != nil {
 			if endPoint.GetHash().IsEqual(blockHash) {
@@ -195,18 +313,92 @@ func (node *Node) Export() error {
 		}*/
 	}
 	if bar != nil {
-		bar.setMax()
+		if i > endNum {
+			bar.setMax()
+		}
 		fmt.Println()
 	}
-	log.Info(fmt.Sprintf("Finish export: blocks(%d)    ------>File:%s", endNum, outFilePath))
+	if i <= endNum {
+		err = checkpoint.save(checkpointPath)
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Stop export: blocks(%d/%d)    ------>File:%s", i-1, endNum, outFilePath))
+		return nil
+	}
+	err = cw.Close()
+	if err != nil {
+		return err
+	}
+	if ew != nil {
+		err = ew.Close()
+		if err != nil {
+			return err
+		}
+	}
+	err = os.RemoveAll(checkpointPath)
+	if err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("Finish export: blocks(%d)    ------>File:%s", count, outFilePath))
 	return nil
 }
 
+// Open the data file of an interrupted export and drop the blocks written
+// after the checkpoint, so that export can continue to append from it.
+func (node *Node) resumeExport(outFilePath string, checkpoint *exportCheckpoint) (*os.File, error) {
+	if checkpoint.Version != ibdVersion {
+		return nil, fmt.Errorf("The checkpoint was exported with data version %d, please use --restart", checkpoint.Version)
+	}
+	if checkpoint.ByID != node.cfg.ByID {
+		return nil, fmt.Errorf("The checkpoint was exported with byid=%v, please use --restart", checkpoint.ByID)
+	}
+	if checkpoint.From != node.exportFrom() {
+		return nil, fmt.Errorf("The checkpoint was exported from %d, please use --restart", checkpoint.From)
+	}
+	if checkpoint.Next > checkpoint.From {
+		var lastHash *hash.Hash
+		if node.cfg.ByID {
+			ib := node.bc.BlockDAG().GetBlockById(checkpoint.Next - 1)
+			if ib != nil {
+				lastHash = ib.GetHash()
+			}
+		} else {
+			lastHash = node.bc.BlockDAG().GetBlockByOrder(checkpoint.Next - 1)
+		}
+		if lastHash == nil || lastHash.String() != checkpoint.LastHash {
+			return nil, fmt.Errorf("The blocks have been changed since the checkpoint, please use --restart")
+		}
+	}
+	outFile, err := os.OpenFile(outFilePath, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := outFile.Stat()
+	if err != nil {
+		outFile.Close()
+		return nil, err
+	}
+	if fi.Size() < checkpoint.Offset {
+		outFile.Close()
+		return nil, fmt.Errorf("The data file is shorter than the checkpoint, please use --restart")
+	}
+	err = outFile.Truncate(checkpoint.Offset)
+	if err == nil {
+		_, err = outFile.Seek(checkpoint.Offset, io.SeekStart)
+	}
+	if err != nil {
+		outFile.Close()
+		return nil, err
+	}
+	log.Info(fmt.Sprintf("Resume export from %d    ------>File:%s", checkpoint.Next, outFilePath))
+	return outFile, nil
+}
+
+// Import the exported data, the main chain tip of database must be right
+// before the first block of data.
 func (node *Node) Import() error {
 	mainTip := node.bc.BlockDAG().GetMainChainTip()
-	if mainTip.GetOrder() > 0 {
-		return fmt.Errorf("Your database is not empty, please empty the database.")
-	}
 	inputFilePath, err := GetIBDFilePath(node.cfg.InputPath)
 	if err != nil {
 		return err
@@ -215,6 +407,33 @@ func (node *Node) Import() error {
 	if err != nil {
 		return err
 	}
+	if isEncrypted(blocksBytes) {
+		passphrase, err := getPassphrase(false)
+		if err != nil {
+			return err
+		}
+		blocksBytes, err = decryptData(blocksBytes, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	blocksBytes, version, from, prev, err := readIBDData(blocksBytes)
+	if err != nil {
+		return err
+	}
+	if mainTip.GetOrder() != uint(from)-1 {
+		if from == 1 {
+			return fmt.Errorf("Your database is not empty, please empty the database.")
+		}
+		return fmt.Errorf("The data starts from block %d, but the main chain tip of database is %d", from, mainTip.GetOrder())
+	}
+	// The data must continue the blocks of database, not only their number.
+	if prev != nil && !prev.IsEqual(mainTip.GetHash()) {
+		return fmt.Errorf("The data continues block %s, but the block %d of database is %s", prev, from-1, mainTip.GetHash())
+	}
+	if len(blocksBytes) < 4 {
+		return fmt.Errorf("Import data is broken")
+	}
 	offset := 0
 	maxOrder := dbnamespace.ByteOrder.Uint32(blocksBytes[offset : offset+4])
 	offset += 4
@@ -229,15 +448,22 @@ func (node *Node) Import() error {
 	} else {
 		log.Info("Import...")
 	}
-	for i := uint32(1); i <= maxOrder; i++ {
-		ibdb := &IBDBlock{}
-		err := ibdb.Decode(blocksBytes[offset:])
-		if err != nil {
-			return err
+	// The blocks are decoded and their signatures are checked concurrently,
+	// but they are still accepted one by one in order. Checking signatures
+	// ahead only takes the time away from the import on a single CPU.
+	sigCache := node.sigCache
+	if runtime.GOMAXPROCS(0) == 1 {
+		sigCache = nil
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	checksum := version >= ibdChecksumVersion
+	for result := range decodeBlocks(blocksBytes[offset:], maxOrder, checksum, node.cfg.Workers, sigCache, quit) {
+		decoded := <-result
+		if decoded.err != nil {
+			return decoded.err
 		}
-		offset += 4 + int(ibdb.length)
-
-		err = node.bc.FastAcceptBlock(ibdb.blk)
+		err = node.bc.FastAcceptBlock(decoded.block)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResumeExport(t *testing.T) {
+	node, teardown := createTestNode(t, 10)
+	defer teardown()
+
+	export := func(dir string, limit uint, restart bool) []byte {
+		cfg := *node.cfg
+		cfg.OutputPath = dir
+		cfg.Restart = restart
+		node.cfg = &cfg
+		node.exportLimit = limit
+		if err := node.Export(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ReadFile(filepath.Join(dir, defaultFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tempDir, err := ioutil.TempDir("", "fastibd-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fullDir := filepath.Join(tempDir, "full")
+	resumeDir := filepath.Join(tempDir, "resume")
+	os.MkdirAll(fullDir, 0700)
+	os.MkdirAll(resumeDir, 0700)
+	full := export(fullDir, 0, false)
+	checkpointPath := getCheckpointPath(filepath.Join(resumeDir, defaultFileName))
+
+	partial := export(resumeDir, 3, false)
+	if !Exists(checkpointPath) || len(partial) >= len(full) {
+		t.Fatal("expect an interrupted export with checkpoint")
+	}
+	// The data written after the checkpoint is dropped when resuming.
+	err = ioutil.WriteFile(filepath.Join(resumeDir, defaultFileName), append(partial, 1, 2, 3), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	export(resumeDir, 4, false)
+	resumed := export(resumeDir, 0, false)
+	if Exists(checkpointPath) {
+		t.Fatal("expect the checkpoint is removed after export")
+	}
+	if !bytes.Equal(full, resumed) {
+		t.Fatal("expect the resumed export is identical to the full one")
+	}
+
+	// Restart ignores the checkpoint.
+	export(resumeDir, 3, false)
+	restarted := export(resumeDir, 0, true)
+	if !bytes.Equal(full, restarted) || Exists(checkpointPath) {
+		t.Fatal("expect the restarted export is identical to the full one")
+	}
+}
+
+func TestExportRange(t *testing.T) {
+	node, teardown := createTestNode(t, 25)
+	defer teardown()
+	tempDir, err := ioutil.TempDir("", "fastibd-range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	export := func(dir string, from, to uint) {
+		cfg := *node.cfg
+		cfg.OutputPath = dir
+		cfg.From = from
+		cfg.To = to
+		node.cfg = &cfg
+		if err := node.Export(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rangeDir := filepath.Join(tempDir, "range")
+	tailDir := filepath.Join(tempDir, "tail")
+	os.MkdirAll(rangeDir, 0700)
+	os.MkdirAll(tailDir, 0700)
+	export(rangeDir, 10, 20)
+	// The end beyond the main chain tip is clamped.
+	export(tailDir, 21, 100)
+
+	for _, blocks := range []int{0, 5} {
+		importNode, importTeardown := createTestNode(t, blocks)
+		importNode.cfg.InputPath = rangeDir
+		err := importNode.Import()
+		importTeardown()
+		if err == nil {
+			t.Fatalf("expect the range can't be imported onto %d blocks", blocks)
+		}
+	}
+
+	importNode, importTeardown := createTestNode(t, 9)
+	defer importTeardown()
+
+	// The range continuing another block than the one of database is
+	// rejected, though the main chain tip has the same order.
+	rangePath := filepath.Join(rangeDir, defaultFileName)
+	data, err := ioutil.ReadFile(rangePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDir := filepath.Join(tempDir, "other")
+	os.MkdirAll(otherDir, 0700)
+	other := append([]byte{}, data...)
+	other[len(ibdMagic)+6] ^= 0xff
+	if err := ioutil.WriteFile(filepath.Join(otherDir, defaultFileName), other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	importNode.cfg.InputPath = otherDir
+	if err := importNode.Import(); err == nil || !strings.Contains(err.Error(), "continues block") {
+		t.Fatalf("expect the range doesn't continue the database, but %v", err)
+	}
+	for i, dir := range []string{rangeDir, tailDir} {
+		importNode.cfg.InputPath = dir
+		if err := importNode.Import(); err != nil {
+			t.Fatal(err)
+		}
+		order := uint(20 + 5*i)
+		tip := importNode.bc.BlockDAG().GetMainChainTip()
+		if tip.GetOrder() != order || !tip.GetHash().IsEqual(node.bc.BlockDAG().GetBlockByOrder(order)) {
+			t.Fatalf("expect the tip at %d connects, but %d", order, tip.GetOrder())
+		}
+	}
+
+	// The start of a range exported by id can't be checked by import.
+	byIDDir := filepath.Join(tempDir, "byid")
+	os.MkdirAll(byIDDir, 0700)
+	cfg := *node.cfg
+	cfg.OutputPath = byIDDir
+	cfg.From = 10
+	cfg.To = 0
+	cfg.ByID = true
+	node.cfg = &cfg
+	if err := node.Export(); err == nil {
+		t.Fatal("expect the range can't be exported by id")
+	}
+	if Exists(filepath.Join(byIDDir, defaultFileName)) {
+		t.Fatal("expect no data is written for the range by id")
+	}
+
+	verifyNode := &Node{cfg: &Config{InputPath: rangeDir}}
+	if _, err := verifyNode.Verify(); err == nil {
+		t.Fatal("expect the range can't be verified without the blocks before it")
+	}
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2020.
+ * Project:qitmeer
+ * File:pipeline.go
+ */
+
+package main
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// The number of recent blocks whose outputs are kept for checking the
+// signatures spending them ahead of the import
+const recentBlocksWindow = 1000
+
+// The block decoded from the imported data
+type decodedBlock struct {
+	block *types.SerializedBlock
+	err   error
+}
+
+type decodeJob struct {
+	bytes  []byte
+	order  uint32
+	result chan *decodedBlock
+}
+
+// The transactions of the recent blocks of data by hash
+type recentTxs struct {
+	sync.RWMutex
+	txs    map[hash.Hash]*types.Transaction
+	blocks map[uint32][]hash.Hash
+}
+
+func (r *recentTxs) add(order uint32, block *types.SerializedBlock) {
+	r.Lock()
+	defer r.Unlock()
+
+	hashes := make([]hash.Hash, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		r.txs[*tx.Hash()] = tx.Tx
+		hashes = append(hashes, *tx.Hash())
+	}
+	r.blocks[order] = hashes
+	if order <= recentBlocksWindow {
+		return
+	}
+	for _, h := range r.blocks[order-recentBlocksWindow] {
+		delete(r.txs, h)
+	}
+	delete(r.blocks, order-recentBlocksWindow)
+}
+
+// Return the script of the output, it's nil if the output isn't in the
+// recent blocks.
+func (r *recentTxs) pkScript(outPoint *types.TxOutPoint) []byte {
+	r.RLock()
+	defer r.RUnlock()
+
+	tx, ok := r.txs[outPoint.Hash]
+	if !ok || int(outPoint.OutIndex) >= len(tx.TxOut) {
+		return nil
+	}
+	return tx.TxOut[outPoint.OutIndex].PkScript
+}
+
+// Decode the given number of blocks from data in a pool of workers ahead of
+// the serial import. The results are delivered in the order of data and only
+// a bounded number of blocks are decoded ahead. Closing quit stops decoding.
+//
+// The workers also run the scripts of the inputs spending the recent blocks,
+// the valid signatures are added to sigCache, so the import finds them there
+// instead of checking them again. Only the import decides whether a block is
+// valid, an input whose script fails or whose output is unknown here is
+// checked by the import as usual.
+func decodeBlocks(data []byte, count uint32, checksum bool, workers int,
+	sigCache *txscript.SigCache, quit <-chan struct{}) <-chan chan *decodedBlock {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	recent := &recentTxs{txs: map[hash.Hash]*types.Transaction{}, blocks: map[uint32][]hash.Hash{}}
+	jobs := make(chan *decodeJob)
+	results := make(chan chan *decodedBlock, workers*2)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				block, _, err := decodeBlock(job.bytes, job.order, checksum)
+				if err == nil {
+					recent.add(job.order, block)
+					if sigCache != nil {
+						checkScripts(block, job.order, recent, sigCache)
+					}
+				}
+				job.result <- &decodedBlock{block: block, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(results)
+		defer close(jobs)
+		head := (&IBDBlock{checksum: checksum}).headSize()
+		offset := 0
+		for i := uint32(1); i <= count; i++ {
+			job := &decodeJob{order: i, result: make(chan *decodedBlock, 1)}
+			length := len(data) - offset
+			if offset+head <= len(data) {
+				size := head + int(dbnamespace.ByteOrder.Uint32(data[offset:offset+4]))
+				if size <= length {
+					length = size
+				}
+			}
+			job.bytes = data[offset : offset+length]
+			offset += length
+			select {
+			case jobs <- job:
+			case <-quit:
+				return
+			}
+			select {
+			case results <- job.result:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// Run the scripts of the inputs of block spending the recent blocks. The
+// height of block isn't known before it's accepted, its order is used for the
+// script flags instead, since a signature is cached only when it's valid
+// whatever the flags are.
+func checkScripts(block *types.SerializedBlock, order uint32, recent *recentTxs, sigCache *txscript.SigCache) {
+	flags := blockchain.ScriptFlagsForHeight(uint64(order), params.ActiveNetParams.Params)
+	for _, tx := range block.Transactions() {
+		for i, txIn := range tx.Tx.TxIn {
+			if txIn.PreviousOut.OutIndex == math.MaxUint32 {
+				continue
+			}
+			pkScript := recent.pkScript(&txIn.PreviousOut)
+			if pkScript == nil {
+				continue
+			}
+			vm, err := txscript.NewEngine(pkScript, tx.Tx, i, flags, txscript.DefaultScriptVersion, sigCache)
+			if err != nil {
+				continue
+			}
+			vm.Execute()
+		}
+	}
+}
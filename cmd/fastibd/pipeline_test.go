@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBlocks(t *testing.T) {
+	node, teardown := createTestNode(t, 20)
+	defer teardown()
+	data, tempDir := exportTestData(t, node)
+	defer os.RemoveAll(tempDir)
+
+	for _, workers := range []int{1, 4} {
+		quit := make(chan struct{})
+		order := uint(1)
+		for result := range decodeBlocks(data[4:], 20, true, workers, nil, quit) {
+			decoded := <-result
+			if decoded.err != nil {
+				t.Fatal(decoded.err)
+			}
+			expect := node.bc.BlockDAG().GetBlockByOrder(order)
+			if !decoded.block.Hash().IsEqual(expect) {
+				t.Fatalf("expect block %s at order %d with %d workers", expect, order, workers)
+			}
+			order++
+		}
+		close(quit)
+		if order != 21 {
+			t.Fatalf("expect 20 blocks, but %d", order-1)
+		}
+	}
+
+	// The broken block is reported with its order.
+	quit := make(chan struct{})
+	var err error
+	for result := range decodeBlocks(data[4:len(data)-10], 20, true, 4, nil, quit) {
+		if decoded := <-result; decoded.err != nil {
+			err = decoded.err
+			break
+		}
+	}
+	close(quit)
+	if err == nil || !strings.Contains(err.Error(), "(20)") {
+		t.Fatalf("expect block 20 is broken, but %v", err)
+	}
+}
+
+func TestImportChecksSignaturesAhead(t *testing.T) {
+	// The signatures are checked ahead only with more than one CPU.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	node, teardown := createSpendingTestNode(t, 40, 2)
+	defer teardown()
+	_, tempDir := exportTestData(t, node)
+	defer os.RemoveAll(tempDir)
+
+	importNode, importTeardown := createTestNode(t, 0)
+	defer importTeardown()
+	importNode.cfg.InputPath = tempDir
+	importNode.cfg.Workers = 4
+	if err := importNode.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if order := importNode.bc.BlockDAG().GetMainChainTip().GetOrder(); order != 40 {
+		t.Fatalf("expect 40 blocks imported, but %d", order)
+	}
+	// Every signature is found in the cache when its block is accepted.
+	stats := importNode.sigCache.Stats()
+	if expect := uint64((40 - testSpendDepth) * 2); stats.Hits != expect {
+		t.Fatalf("expect %d signatures found in the cache, but %d", expect, stats.Hits)
+	}
+
+	// The signatures are all checked by the import without the cache.
+	importNode, importTeardown = createTestNode(t, 0)
+	defer importTeardown()
+	importNode.cfg.InputPath = tempDir
+	cache := importNode.sigCache
+	importNode.sigCache = nil
+	if err := importNode.Import(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Entries != uint((40-testSpendDepth)*2) {
+		t.Fatalf("expect the signatures are checked by the import, but %+v", stats)
+	}
+}
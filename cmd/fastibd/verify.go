@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2020.
+ * Project:qitmeer
+ * File:verify.go
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// The result of verifying the exported data
+type VerifyResult struct {
+	Total   uint32
+	Checked uint32
+	Errors  []error
+}
+
+// Verify the exported data without touching the database. Every block is
+// decoded, linked to the blocks before it and its transaction scripts are
+// validated against the outputs of them which aren't spent yet. It stops at
+// the first error unless the config asks to continue.
+func (node *Node) Verify() (*VerifyResult, error) {
+	inputFilePath, err := GetIBDFilePath(node.cfg.InputPath)
+	if err != nil {
+		return nil, err
+	}
+	blocksBytes, err := ReadFile(inputFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(blocksBytes) {
+		passphrase, err := getPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		blocksBytes, err = decryptData(blocksBytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	blocksBytes, version, from, _, err := readIBDData(blocksBytes)
+	if err != nil {
+		return nil, err
+	}
+	if from != 1 {
+		return nil, fmt.Errorf("The data starts from block %d, only the data from the first block can be verified", from)
+	}
+	if len(blocksBytes) < 4 {
+		return nil, fmt.Errorf("Import data is broken")
+	}
+	result := &VerifyResult{Total: dbnamespace.ByteOrder.Uint32(blocksBytes[:4])}
+
+	genesis := types.NewBlock(params.ActiveNetParams.GenesisBlock)
+	known := map[hash.Hash]bool{*genesis.Hash(): true}
+	view := blockchain.NewUtxoViewpoint()
+	for _, tx := range genesis.Transactions() {
+		view.AddTxOuts(tx, genesis.Hash())
+	}
+
+	offset := 4
+	checksum := version >= ibdChecksumVersion
+	for order := uint32(1); order <= result.Total; order++ {
+		block, size, err := decodeBlock(blocksBytes[offset:], order, checksum)
+		offset += size
+		result.Checked++
+		if err == nil {
+			err = verifyBlock(block, order, known, view)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err)
+			// The blocks after a truncated one can't be found.
+			if !node.cfg.Continue || size == 0 {
+				break
+			}
+			log.Error(err.Error())
+		}
+	}
+
+	log.Info(fmt.Sprintf("Finish verify: blocks(%d/%d) errors(%d)    ------>File:%s", result.Checked, result.Total, len(result.Errors), inputFilePath))
+	if len(result.Errors) == 1 {
+		return result, result.Errors[0]
+	} else if len(result.Errors) > 1 {
+		return result, fmt.Errorf("%d blocks failed to verify, the first one: %s", len(result.Errors), result.Errors[0])
+	}
+	return result, nil
+}
+
+// Check the parents and transaction scripts of block and spend the outputs
+// referenced by its transactions, then add it and its outputs for the blocks
+// after it.
+func verifyBlock(block *types.SerializedBlock, order uint32, known map[hash.Hash]bool, view *blockchain.UtxoViewpoint) error {
+	defer func() {
+		known[*block.Hash()] = true
+		for _, tx := range block.Transactions() {
+			view.AddTxOuts(tx, block.Hash())
+		}
+	}()
+	for _, parent := range block.Block().Parents {
+		if !known[*parent] {
+			return fmt.Errorf("Block (%d) %s: the parent %s is not before it", order, block.Hash(), parent)
+		}
+	}
+	// The scripts are validated with the flags of consensus at the height
+	// encoded in the coinbase, which the block chain enforces.
+	txs := block.Block().Transactions
+	if len(txs) == 0 || len(txs[0].TxIn) == 0 {
+		return fmt.Errorf("Block (%d) %s: no coinbase", order, block.Hash())
+	}
+	height, err := blockchain.ExtractCoinbaseHeight(txs[0])
+	if err != nil {
+		return fmt.Errorf("Block (%d) %s: %s", order, block.Hash(), err)
+	}
+	scriptFlags := blockchain.ScriptFlagsForHeight(height, params.ActiveNetParams.Params)
+	for _, tx := range block.Transactions() {
+		if tx.Tx.IsCoinBase() {
+			continue
+		}
+		for _, txIn := range tx.Tx.TxIn {
+			entry := view.LookupEntry(txIn.PreviousOut)
+			if entry != nil && entry.IsSpent() {
+				return fmt.Errorf("Block (%d) %s: the output %v referenced from transaction %s is already spent", order, block.Hash(), txIn.PreviousOut, tx.Hash())
+			}
+		}
+		err := blockchain.ValidateTransactionScripts(tx, view, scriptFlags, nil)
+		if err != nil {
+			return fmt.Errorf("Block (%d) %s: %s", order, block.Hash(), err)
+		}
+		// The outputs are spent for the transactions after it.
+		for _, txIn := range tx.Tx.TxIn {
+			view.LookupEntry(txIn.PreviousOut).Spend()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	node, teardown := createTestNode(t, 10)
+	defer teardown()
+	tempDir, err := ioutil.TempDir("", "fastibd-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	node.cfg.OutputPath = tempDir
+	if err := node.Export(); err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(tempDir, defaultFileName)
+	data, err := ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyNode := &Node{cfg: &Config{InputPath: tempDir}}
+	verify := func(data []byte, cont bool) (*VerifyResult, error) {
+		if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		verifyNode.cfg.Continue = cont
+		return verifyNode.Verify()
+	}
+	result, err := verify(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 10 || result.Checked != 10 {
+		t.Fatalf("expect 10 blocks checked, but %d/%d", result.Checked, result.Total)
+	}
+
+	// The truncated data
+	for _, cont := range []bool{false, true} {
+		result, err = verify(data[:len(data)-10], cont)
+		if err == nil || !strings.Contains(err.Error(), "Block (10)") {
+			t.Fatalf("expect block 10 is broken, but %v", err)
+		}
+		if result.Checked != 10 || len(result.Errors) != 1 {
+			t.Fatalf("expect 10 blocks checked and 1 error, but %d and %d", result.Checked, len(result.Errors))
+		}
+	}
+
+	// Drop the second block, the third one loses its parent.
+	body := data[ibdHeaderSize+4:]
+	first := 8 + int(dbnamespace.ByteOrder.Uint32(body))
+	second := 8 + int(dbnamespace.ByteOrder.Uint32(body[first:]))
+	broken := append([]byte{}, data[:ibdHeaderSize]...)
+	var count [4]byte
+	dbnamespace.ByteOrder.PutUint32(count[:], 9)
+	broken = append(broken, count[:]...)
+	broken = append(broken, body[:first]...)
+	broken = append(broken, body[first+second:]...)
+	result, err = verify(broken, false)
+	if err == nil || !strings.Contains(err.Error(), "Block (2)") || result.Checked != 2 {
+		t.Fatalf("expect block 2 loses its parent, but %v", err)
+	}
+	result, err = verify(broken, true)
+	if err == nil || result.Checked != 9 || len(result.Errors) != 1 {
+		t.Fatalf("expect all blocks checked with 1 error, but %v", err)
+	}
+}
+
+// Spend the coinbase output of a block in the next block and again in the one
+// after it.
+func TestVerifyDoubleSpend(t *testing.T) {
+	genesis := types.NewBlock(params.ActiveNetParams.GenesisBlock)
+	known := map[hash.Hash]bool{*genesis.Hash(): true}
+	view := blockchain.NewUtxoViewpoint()
+	parent := genesis.Hash()
+	newBlock := func(height byte, txs ...*types.Transaction) *types.SerializedBlock {
+		coinbase := types.NewTransaction()
+		coinbase.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(&hash.Hash{}, math.MaxUint32),
+			Sequence:    math.MaxUint32,
+			SignScript:  []byte{txscript.OP_DATA_1, height},
+		})
+		// The hash of a transaction leaves out the input scripts, the
+		// amount tells the coinbases apart.
+		coinbase.AddTxOut(types.NewTxOutput(uint64(height), []byte{txscript.OP_TRUE}))
+		header := genesis.Block().Header
+		header.Timestamp = header.Timestamp.Add(time.Duration(height) * time.Second)
+		block := types.NewBlock(&types.Block{
+			Header:       header,
+			Parents:      []*hash.Hash{parent},
+			Transactions: append([]*types.Transaction{coinbase}, txs...),
+		})
+		parent = block.Hash()
+		return block
+	}
+	spend := func(outPoint *types.TxOutPoint, amount uint64) *types.Transaction {
+		tx := types.NewTransaction()
+		tx.AddTxIn(types.NewTxInput(outPoint, nil))
+		tx.AddTxOut(types.NewTxOutput(amount, []byte{txscript.OP_TRUE}))
+		return tx
+	}
+
+	first := newBlock(1)
+	if err := verifyBlock(first, 1, known, view); err != nil {
+		t.Fatal(err)
+	}
+	outPoint := types.NewOutPoint(first.Transactions()[0].Hash(), 0)
+	if err := verifyBlock(newBlock(2, spend(outPoint, 0)), 2, known, view); err != nil {
+		t.Fatal(err)
+	}
+	err := verifyBlock(newBlock(3, spend(outPoint, 1)), 3, known, view)
+	if err == nil || !strings.Contains(err.Error(), "already spent") {
+		t.Fatalf("expect the output is already spent, but %v", err)
+	}
+}
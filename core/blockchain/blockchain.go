@@ -44,12 +44,13 @@ type BlockChain struct {
 	// separate mutex.
 	checkpointsByLayer map[uint64]*params.Checkpoint
 
-	db            database.DB
-	dbInfo        *databaseInfo
-	timeSource    MedianTimeSource
-	notifications NotificationCallback
-	sigCache      *txscript.SigCache
-	indexManager  IndexManager
+	db             database.DB
+	dbInfo         *databaseInfo
+	timeSource     MedianTimeSource
+	notifications  NotificationCallback
+	sigCache       *txscript.SigCache
+	validateConfig *ValidateConfig
+	indexManager   IndexManager
 
 	// subsidyCache is the cache that provides quick lookup of subsidy
 	// values.
@@ -174,6 +175,13 @@ type Config struct {
 
 	// Cache Invalid tx
 	CacheInvalidTx bool
+
+	// ValidateConfig tunes the validation of transaction scripts.  Its
+	// BlockHeight and BlockHeightOf are ignored, they're filled in for every
+	// connected block.
+	//
+	// This field can be nil to use the default settings.
+	ValidateConfig *ValidateConfig
 }
 
 // BestState houses information about the current best block and other info
@@ -260,6 +268,7 @@ func New(config *Config) (*BlockChain, error) {
 		timeSource:         config.TimeSource,
 		notifications:      config.Notifications,
 		sigCache:           config.SigCache,
+		validateConfig:     config.ValidateConfig,
 		indexManager:       config.IndexManager,
 		index:              newBlockIndex(config.DB, par),
 		orphans:            make(map[hash.Hash]*orphanBlock),
@@ -7,6 +7,7 @@
 package blockchain
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -335,6 +336,14 @@ const (
 	// ErrNoViewpoint
 	ErrNoViewpoint
 
+	// ErrSpentTxOut indicates a transaction output referenced by an input
+	// has already been spent by another transaction in the same view.
+	ErrSpentTxOut
+
+	// ErrDoubleSpend indicates an output is spent by more than one input
+	// in the same block.
+	ErrDoubleSpend
+
 	// numErrorCodes is the maximum error code number used in tests.
 	numErrorCodes
 )
@@ -410,6 +419,8 @@ var errorCodeStrings = map[ErrorCode]string{
 
 	ErrNoBlueCoinbase: "ErrNoBlueCoinbase",
 	ErrNoViewpoint:    "ErrNoViewpoint",
+	ErrSpentTxOut:     "ErrSpentTxOut",
+	ErrDoubleSpend:    "ErrDoubleSpend",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -420,6 +431,12 @@ func (e ErrorCode) String() string {
 	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
 }
 
+// Error satisfies the error interface, so an ErrorCode can be the target of
+// errors.Is to match any RuleError with that code.
+func (e ErrorCode) Error() string {
+	return e.String()
+}
+
 // RuleError identifies a rule violation.  It is used to indicate that
 // processing of a block or transaction failed due to one of the many validation
 // rules.  The caller can use type assertions to determine if a failure was
@@ -435,6 +452,29 @@ func (e RuleError) Error() string {
 	return e.Description
 }
 
+// Is reports whether the target is a RuleError or an ErrorCode with the same
+// code, it's used by errors.Is.
+func (e RuleError) Is(target error) bool {
+	switch t := target.(type) {
+	case RuleError:
+		return e.ErrorCode == t.ErrorCode
+	case ErrorCode:
+		return e.ErrorCode == t
+	}
+	return false
+}
+
+// IsScriptError returns whether the error, or any error it wraps, is a
+// RuleError caused by a transaction script that is malformed or fails to
+// validate.
+func IsScriptError(err error) bool {
+	var rerr RuleError
+	if !errors.As(err, &rerr) {
+		return false
+	}
+	return rerr.ErrorCode == ErrScriptMalformed || rerr.ErrorCode == ErrScriptValidation
+}
+
 // ruleError creates an RuleError given a set of arguments.
 func ruleError(c ErrorCode, desc string) RuleError {
 	return RuleError{ErrorCode: c, Description: desc}
@@ -7,14 +7,64 @@
 package blockchain
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"runtime"
+	"sync"
+	"sync/atomic"
 
+	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
 )
 
+// enginePool holds script engines which are reused across validated inputs in
+// order to avoid reallocating the virtual machine state for every input.
+var enginePool = sync.Pool{
+	New: func() interface{} {
+		atomic.AddUint64(&enginesCreated, 1)
+		return &txscript.Engine{}
+	},
+}
+
+// enginesCreated counts the script engines allocated by enginePool.
+var enginesCreated uint64
+
+// DefaultMaxRedeemScriptOps is the default maximum number of non-push
+// operations the redeem script of a pay-to-script-hash output may execute
+// while its input is being validated.
+const DefaultMaxRedeemScriptOps = 201
+
+// ValidateConfig tunes how transaction scripts are validated.
+type ValidateConfig struct {
+	// Workers is the number of goroutines validating inputs concurrently.
+	// Zero means three per processor core.
+	Workers int
+
+	// CoinbaseMaturity is the number of blocks required before a coinbase
+	// output can be spent.  Zero disables the check, which is left to the
+	// DAG when blocks are connected.
+	CoinbaseMaturity uint16
+
+	// BlockHeight is the height of the block the spending transaction is
+	// validated for.  It's set for each block, so the block chain fills it
+	// in for the block being connected.
+	BlockHeight uint
+
+	// BlockHeightOf returns the height of the block that contains a coinbase
+	// output, it's required when CoinbaseMaturity isn't zero.  The block
+	// chain looks the height up in its block index.  The output of a block
+	// it can't find is treated as missing.
+	BlockHeightOf func(blockHash *hash.Hash) (uint, bool)
+
+	// MaxRedeemScriptOps is the maximum number of non-push operations a
+	// pay-to-script-hash redeem script may execute.  Zero means
+	// DefaultMaxRedeemScriptOps.
+	MaxRedeemScriptOps int
+}
+
 // txValidateItem holds a transaction along with which input to validate.
 type txValidateItem struct {
 	txInIndex int
@@ -32,6 +82,9 @@ type txValidator struct {
 	utxoView     *UtxoViewpoint
 	flags        txscript.ScriptFlags
 	sigCache     *txscript.SigCache
+	maxRedeemOps int
+	workers      int
+	config       ValidateConfig
 }
 
 // sendResult sends the result of a script pair validation on the internal
@@ -45,6 +98,105 @@ func (v *txValidator) sendResult(result error) {
 	}
 }
 
+// validateItem validates the script pair of a single transaction input.  The
+// returned rule error identifies the input by its transaction hash and index.
+func (v *txValidator) validateItem(txVI *txValidateItem) error {
+	// Ensure the referenced input transaction is available.
+	txIn := txVI.txIn
+	utxo := v.utxoView.LookupEntry(txIn.PreviousOut)
+	if utxo == nil {
+		str := fmt.Sprintf("unable to find unspent "+
+			"output %v referenced from "+
+			"transaction %s:%d",
+			txIn.PreviousOut, txVI.tx.Hash(),
+			txVI.txInIndex)
+		return ruleError(ErrMissingTxOut, str)
+	}
+
+	// Ensure the referenced output was not spent by another
+	// transaction in this view.  Note that the outputs spent by
+	// a block are already marked spent by the spending
+	// transaction itself when its scripts are checked.
+	if utxo.IsSpent() && utxo.SpentBy() != nil &&
+		!utxo.SpentBy().IsEqual(txVI.tx.Hash()) {
+		str := fmt.Sprintf("output %v referenced from "+
+			"transaction %s:%d has already been spent "+
+			"by transaction %s", txIn.PreviousOut,
+			txVI.tx.Hash(), txVI.txInIndex, utxo.SpentBy())
+		return ruleError(ErrSpentTxOut, str)
+	}
+
+	// Ensure the referenced coinbase output has matured.
+	if utxo.IsCoinBase() && v.config.CoinbaseMaturity > 0 {
+		if err := v.checkMaturity(txVI, utxo); err != nil {
+			return err
+		}
+	}
+
+	// Ensure the referenced input transaction public key
+	// script is available.
+	pkScript := utxo.PkScript()
+	sigScript := txIn.SignScript
+	vm := enginePool.Get().(*txscript.Engine)
+	err := vm.Reset(pkScript, txVI.tx.Transaction(),
+		txVI.txInIndex, v.flags, txscript.DefaultScriptVersion, v.sigCache)
+	if err != nil {
+		enginePool.Put(vm)
+		str := fmt.Sprintf("failed to parse input "+
+			"%s:%d which references output %v - "+
+			"%v (input script "+
+			"bytes %x, prev output script bytes %x)",
+			txVI.tx.Hash(), txVI.txInIndex,
+			txIn.PreviousOut, err,
+			sigScript, pkScript)
+		return ruleError(ErrScriptMalformed, str)
+	}
+	vm.SetMaxRedeemScriptOps(v.maxRedeemOps)
+
+	// Execute the script pair.  The engine is returned to the
+	// pool either way since it is fully reset before reuse.
+	err = vm.Execute()
+	enginePool.Put(vm)
+	if err != nil {
+		str := fmt.Sprintf("failed to validate input "+
+			"%s:%d which references output %v - "+
+			"%v (input script "+
+			"bytes %x, prev output script bytes %x)",
+			txVI.tx.Hash(), txVI.txInIndex,
+			txIn.PreviousOut, err,
+			sigScript, pkScript)
+		return ruleError(ErrScriptValidation, str)
+	}
+
+	return nil
+}
+
+// checkMaturity ensures the coinbase output referenced by the passed input is
+// at least CoinbaseMaturity blocks deep from the validated block.
+func (v *txValidator) checkMaturity(txVI *txValidateItem, utxo *UtxoEntry) error {
+	if v.config.BlockHeightOf == nil {
+		return AssertError("ValidateConfig.CoinbaseMaturity is set " +
+			"without BlockHeightOf")
+	}
+	maturity := uint(v.config.CoinbaseMaturity)
+	originHeight, ok := v.config.BlockHeightOf(utxo.BlockHash())
+	if !ok {
+		str := fmt.Sprintf("unable to find the height of block %s "+
+			"containing coinbase output %v referenced from "+
+			"transaction %s:%d", utxo.BlockHash(),
+			txVI.txIn.PreviousOut, txVI.tx.Hash(), txVI.txInIndex)
+		return ruleError(ErrMissingTxOut, str)
+	}
+	if v.config.BlockHeight < originHeight+maturity {
+		str := fmt.Sprintf("tried to spend coinbase output %v "+
+			"from height %d at height %d before required "+
+			"maturity of %d blocks", txVI.txIn.PreviousOut,
+			originHeight, v.config.BlockHeight, maturity)
+		return ruleError(ErrImmatureSpend, str)
+	}
+	return nil
+}
+
 // validateHandler consumes items to validate from the internal validate channel
 // and returns the result of the validation on the internal result channel. It
 // must be run as a goroutine.
@@ -53,82 +205,57 @@ out:
 	for {
 		select {
 		case txVI := <-v.validateChan:
-			// Ensure the referenced input transaction is available.
-			txIn := txVI.txIn
-			utxo := v.utxoView.LookupEntry(txIn.PreviousOut)
-			if utxo == nil {
-				str := fmt.Sprintf("unable to find unspent "+
-					"output %v referenced from "+
-					"transaction %s:%d",
-					txIn.PreviousOut, txVI.tx.Hash(),
-					txVI.txInIndex)
-				err := ruleError(ErrMissingTxOut, str)
-				v.sendResult(err)
-				break out
-			}
-
-			// Ensure the referenced input transaction public key
-			// script is available.
-			pkScript := utxo.PkScript()
-			sigScript := txIn.SignScript
-			vm, err := txscript.NewEngine(pkScript, txVI.tx.Transaction(),
-				txVI.txInIndex, v.flags, txscript.DefaultScriptVersion, v.sigCache)
+			err := v.validateItem(txVI)
+			v.sendResult(err)
 			if err != nil {
-				str := fmt.Sprintf("failed to parse input "+
-					"%s:%d which references output %v - "+
-					"%v (input script "+
-					"bytes %x, prev output script bytes %x)",
-					txVI.tx.Hash(), txVI.txInIndex,
-					txIn.PreviousOut, err,
-					sigScript, pkScript)
-				err := ruleError(ErrScriptMalformed, str)
-				v.sendResult(err)
 				break out
 			}
 
-			// Execute the script pair.
-			if err := vm.Execute(); err != nil {
-				str := fmt.Sprintf("failed to validate input "+
-					"%s:%d which references output %v - "+
-					"%v (input script "+
-					"bytes %x, prev output script bytes %x)",
-					txVI.tx.Hash(), txVI.txInIndex,
-					txIn.PreviousOut, err,
-					sigScript, pkScript)
-				err := ruleError(ErrScriptValidation, str)
-				v.sendResult(err)
-				break out
-			}
-
-			// Validation succeeded.
-			v.sendResult(nil)
-
 		case <-v.quitChan:
 			break out
 		}
 	}
 }
 
+// numWorkers returns the number of goroutines to validate the passed number of
+// items with.
+func (v *txValidator) numWorkers(numItems int) int {
+	// Limit the number of goroutines to do script validation based on the
+	// number of processor cores unless it has been configured.  This help
+	// ensure the system stays reasonably responsive under heavy load.
+	maxGoRoutines := v.workers
+	if maxGoRoutines <= 0 {
+		maxGoRoutines = runtime.NumCPU() * 3
+	}
+	if maxGoRoutines <= 0 {
+		maxGoRoutines = 1
+	}
+	if maxGoRoutines > numItems {
+		maxGoRoutines = numItems
+	}
+	return maxGoRoutines
+}
+
 // Validate validates the scripts for all of the passed transaction inputs using
 // multiple goroutines.
 func (v *txValidator) Validate(items []*txValidateItem) error {
-	if len(items) == 0 {
-		return nil
-	}
+	return v.ValidateCtx(context.Background(), items)
+}
 
-	// Limit the number of goroutines to do script validation based on the
-	// number of processor cores.  This help ensure the system stays
-	// reasonably responsive under heavy load.
-	maxGoRoutines := runtime.NumCPU() * 3
-	if maxGoRoutines <= 0 {
-		maxGoRoutines = 1
+// ValidateCtx validates the scripts for all of the passed transaction inputs
+// like Validate, but it stops and returns the error of the context once the
+// context is done.
+func (v *txValidator) ValidateCtx(ctx context.Context, items []*txValidateItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	if maxGoRoutines > len(items) {
-		maxGoRoutines = len(items)
+	if len(items) == 0 {
+		return nil
 	}
 
 	// Start up validation handlers that are used to asynchronously
 	// validate each transaction input.
+	maxGoRoutines := v.numWorkers(len(items))
 	for i := 0; i < maxGoRoutines; i++ {
 		go v.validateHandler()
 	}
@@ -160,6 +287,10 @@ func (v *txValidator) Validate(items []*txValidateItem) error {
 				close(v.quitChan)
 				return err
 			}
+
+		case <-ctx.Done():
+			close(v.quitChan)
+			return ctx.Err()
 		}
 	}
 
@@ -167,24 +298,102 @@ func (v *txValidator) Validate(items []*txValidateItem) error {
 	return nil
 }
 
+// ValidateAll validates the scripts for all of the passed transaction inputs
+// using multiple goroutines.  Unlike Validate it does not stop at the first
+// failure, instead the errors of every failing input are returned in the order
+// of the items.  It is meant for debugging, block processing should keep using
+// the fast failing Validate.
+func (v *txValidator) ValidateAll(items []*txValidateItem) []error {
+	results := make([]error, len(items))
+	indexChan := make(chan int)
+	var wg sync.WaitGroup
+	maxGoRoutines := v.numWorkers(len(items))
+	for i := 0; i < maxGoRoutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexChan {
+				results[index] = v.validateItem(items[index])
+			}
+		}()
+	}
+	for i := range items {
+		indexChan <- i
+	}
+	close(indexChan)
+	wg.Wait()
+
+	var errs []error
+	for _, err := range results {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
 // newTxValidator returns a new instance of txValidator to be used for
-// validating transaction scripts asynchronously.
-func newTxValidator(utxoView *UtxoViewpoint, flags txscript.ScriptFlags, sigCache *txscript.SigCache) *txValidator {
-	return &txValidator{
+// validating transaction scripts asynchronously.  The config may be nil to use
+// the default settings.
+func newTxValidator(utxoView *UtxoViewpoint, flags txscript.ScriptFlags, sigCache *txscript.SigCache, config *ValidateConfig) *txValidator {
+	v := &txValidator{
 		validateChan: make(chan *txValidateItem),
 		quitChan:     make(chan struct{}),
 		resultChan:   make(chan error),
 		utxoView:     utxoView,
 		sigCache:     sigCache,
 		flags:        flags,
+		maxRedeemOps: DefaultMaxRedeemScriptOps,
 	}
+	if config != nil {
+		v.config = *config
+		v.workers = config.Workers
+		if config.MaxRedeemScriptOps > 0 {
+			v.maxRedeemOps = config.MaxRedeemScriptOps
+		}
+	}
+	return v
 }
 
 // ValidateTransactionScripts validates the scripts for the passed transaction
 // using multiple goroutines.
 func ValidateTransactionScripts(tx *types.Tx, utxoView *UtxoViewpoint, flags txscript.ScriptFlags, sigCache *txscript.SigCache) error {
-	// Collect all of the transaction inputs and required information for
-	// validation.
+	return ValidateTransactionScriptsWithConfig(tx, utxoView, flags, sigCache, nil)
+}
+
+// ValidateTransactionScriptsCtx validates the scripts for the passed
+// transaction like ValidateTransactionScriptsWithConfig, but the validation is
+// aborted with the error of the context once the context is done.
+func ValidateTransactionScriptsCtx(ctx context.Context, tx *types.Tx, utxoView *UtxoViewpoint, flags txscript.ScriptFlags,
+	sigCache *txscript.SigCache, config *ValidateConfig) error {
+	txValItems := txValidateItems(tx)
+	return newTxValidator(utxoView, flags, sigCache, config).ValidateCtx(ctx, txValItems)
+}
+
+// ValidateTransactionScriptsWithConfig validates the scripts for the passed
+// transaction as ValidateTransactionScripts does, using the passed validation
+// config.
+func ValidateTransactionScriptsWithConfig(tx *types.Tx, utxoView *UtxoViewpoint, flags txscript.ScriptFlags,
+	sigCache *txscript.SigCache, config *ValidateConfig) error {
+	// Validate all of the inputs.
+	txValItems := txValidateItems(tx)
+	return newTxValidator(utxoView, flags, sigCache, config).Validate(txValItems)
+
+}
+
+// ValidateTransactionScriptsAll validates the scripts for the passed
+// transaction like ValidateTransactionScriptsWithConfig, but it reports every
+// failing input instead of only the first one.  Each of the returned rule
+// errors identifies its input by the transaction hash and input index.
+func ValidateTransactionScriptsAll(tx *types.Tx, utxoView *UtxoViewpoint, flags txscript.ScriptFlags,
+	sigCache *txscript.SigCache, config *ValidateConfig) []error {
+	txValItems := txValidateItems(tx)
+	return newTxValidator(utxoView, flags, sigCache, config).ValidateAll(txValItems)
+}
+
+// txValidateItems collects all of the transaction inputs and required
+// information for validation.
+func txValidateItems(tx *types.Tx) []*txValidateItem {
 	txIns := tx.Transaction().TxIn
 	txValItems := make([]*txValidateItem, 0, len(txIns))
 	for txInIdx, txIn := range txIns {
@@ -200,17 +409,18 @@ func ValidateTransactionScripts(tx *types.Tx, utxoView *UtxoViewpoint, flags txs
 		}
 		txValItems = append(txValItems, txVI)
 	}
-
-	// Validate all of the inputs.
-	return newTxValidator(utxoView, flags, sigCache).Validate(txValItems)
-
+	return txValItems
 }
 
 // checkBlockScripts executes and validates the scripts for all transactions in
-// the passed block using multiple goroutines.
+// the passed block using multiple goroutines.  The script flags are resolved
+// from the chain params at the height of the block.
 // txTree = true is TxTreeRegular, txTree = false is TxTreeStake.
 func checkBlockScripts(block *types.SerializedBlock, utxoView *UtxoViewpoint,
-	scriptFlags txscript.ScriptFlags, sigCache *txscript.SigCache) error {
+	sigCache *txscript.SigCache, config *ValidateConfig,
+	chainParams *params.Params) error {
+
+	scriptFlags := ScriptFlagsForHeight(uint64(block.Height()), chainParams)
 
 	// Collect all of the transaction inputs and required information for
 	// validation for all transactions in the block into a single slice.
@@ -223,6 +433,9 @@ func checkBlockScripts(block *types.SerializedBlock, utxoView *UtxoViewpoint,
 		numInputs += len(tx.Transaction().TxIn)
 	}
 	txValItems := make([]*txValidateItem, 0, numInputs)
+	// The spender of each output, an output spent twice in the block is
+	// rejected before running any script.
+	spenders := make(map[types.TxOutPoint]*hash.Hash, numInputs)
 	for _, tx := range txs {
 		if tx.IsDuplicate {
 			continue
@@ -232,6 +445,14 @@ func checkBlockScripts(block *types.SerializedBlock, utxoView *UtxoViewpoint,
 			if txIn.PreviousOut.OutIndex == math.MaxUint32 {
 				continue
 			}
+			if spender, ok := spenders[txIn.PreviousOut]; ok {
+				str := fmt.Sprintf("output %v:%d is spent by both "+
+					"transaction %v and %v in the block",
+					txIn.PreviousOut.Hash, txIn.PreviousOut.OutIndex,
+					spender, tx.Hash())
+				return ruleError(ErrDoubleSpend, str)
+			}
+			spenders[txIn.PreviousOut] = tx.Hash()
 
 			txVI := &txValidateItem{
 				txInIndex: txInIdx,
@@ -243,5 +464,5 @@ func checkBlockScripts(block *types.SerializedBlock, utxoView *UtxoViewpoint,
 	}
 
 	// Validate all of the inputs.
-	return newTxValidator(utxoView, scriptFlags, sigCache).Validate(txValItems)
+	return newTxValidator(utxoView, scriptFlags, sigCache, config).Validate(txValItems)
 }
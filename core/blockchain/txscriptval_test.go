@@ -0,0 +1,589 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// buildScriptTestTxs returns a transaction whose outputs are locked by the
+// passed public key scripts together with a transaction spending each of
+// those outputs with the matching signature script.  A utxo view holding the
+// spent outputs is returned as well.
+func buildScriptTestTxs(pkScripts [][]byte, sigScripts [][]byte) (*types.Tx, *UtxoViewpoint) {
+	prevTx := types.NewTransaction()
+	for _, pkScript := range pkScripts {
+		prevTx.AddTxOut(types.NewTxOutput(1, pkScript))
+	}
+	prev := types.NewTx(prevTx)
+
+	view := NewUtxoViewpoint()
+	view.AddTxOuts(prev, &hash.Hash{})
+
+	tx := types.NewTransaction()
+	for i := range pkScripts {
+		tx.AddTxIn(types.NewTxInput(types.NewOutPoint(prev.Hash(), uint32(i)), sigScripts[i]))
+	}
+	tx.AddTxOut(types.NewTxOutput(1, []byte{txscript.OP_TRUE}))
+	return types.NewTx(tx), view
+}
+
+// buildTrueScriptTx returns a transaction with the requested number of inputs
+// which all spend outputs that are locked by OP_TRUE.
+func buildTrueScriptTx(numInputs int) (*types.Tx, *UtxoViewpoint) {
+	pkScripts := make([][]byte, numInputs)
+	sigScripts := make([][]byte, numInputs)
+	for i := 0; i < numInputs; i++ {
+		pkScripts[i] = []byte{txscript.OP_TRUE}
+		sigScripts[i] = nil
+	}
+	return buildScriptTestTxs(pkScripts, sigScripts)
+}
+
+func TestEngineResetNoContamination(t *testing.T) {
+	flags := txscript.ScriptBip16 | txscript.ScriptVerifyCleanStack
+
+	// The first input fails while leaving several items on the data stack.
+	// The second input only passes the clean stack rule if nothing from the
+	// first execution survives the reset.
+	tx, view := buildScriptTestTxs(
+		[][]byte{{txscript.OP_FALSE}, {txscript.OP_TRUE}},
+		[][]byte{{txscript.OP_1, txscript.OP_1, txscript.OP_1}, nil})
+
+	vm := &txscript.Engine{}
+	pkScript := view.LookupEntry(tx.Tx.TxIn[0].PreviousOut).PkScript()
+	err := vm.Reset(pkScript, tx.Transaction(), 0, flags, txscript.DefaultScriptVersion, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Execute(); err == nil {
+		t.Fatal("expected the first input to fail")
+	}
+
+	pkScript = view.LookupEntry(tx.Tx.TxIn[1].PreviousOut).PkScript()
+	err = vm.Reset(pkScript, tx.Transaction(), 1, flags, txscript.DefaultScriptVersion, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.GetStack()) != 0 || len(vm.GetAltStack()) != 0 {
+		t.Fatal("stacks were not cleared by reset")
+	}
+	if err := vm.Execute(); err != nil {
+		t.Fatalf("reused engine failed on a clean input: %v", err)
+	}
+}
+
+func TestValidateReusesEngines(t *testing.T) {
+	// A collection empties the pool, so keep the collector away while
+	// counting the allocated engines.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	const workers = 2
+	tx, view := buildTrueScriptTx(200)
+	config := &ValidateConfig{Workers: workers}
+	before := atomic.LoadUint64(&enginesCreated)
+	for i := 0; i < 3; i++ {
+		err := ValidateTransactionScriptsWithConfig(tx, view, txscript.ScriptBip16|txscript.ScriptVerifyCleanStack, nil, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The race detector drops some of the engines put back into the pool,
+	// so only require that most inputs reused an engine.
+	created := atomic.LoadUint64(&enginesCreated) - before
+	validated := uint64(3 * len(tx.Tx.TxIn))
+	if created*2 > validated {
+		t.Fatalf("validated %d inputs with %d engines", validated, created)
+	}
+}
+
+func BenchmarkEngineNew(b *testing.B) {
+	tx, view := buildTrueScriptTx(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i, txIn := range tx.Tx.TxIn {
+			pkScript := view.LookupEntry(txIn.PreviousOut).PkScript()
+			vm, err := txscript.NewEngine(pkScript, tx.Transaction(), i, 0, txscript.DefaultScriptVersion, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := vm.Execute(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkEnginePool(b *testing.B) {
+	tx, view := buildTrueScriptTx(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i, txIn := range tx.Tx.TxIn {
+			pkScript := view.LookupEntry(txIn.PreviousOut).PkScript()
+			vm := enginePool.Get().(*txscript.Engine)
+			err := vm.Reset(pkScript, tx.Transaction(), i, 0, txscript.DefaultScriptVersion, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = vm.Execute()
+			enginePool.Put(vm)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestValidateSpentTxOut(t *testing.T) {
+	tx, view := buildTrueScriptTx(1)
+	if err := ValidateTransactionScripts(tx, view, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spend the output in the view, then try to spend it again by another
+	// transaction.
+	genesis := newBlockNode(&params.PrivNetParams.GenesisBlock.Header, nil)
+	if err := view.connectTransaction(tx, genesis, 0, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateTransactionScripts(tx, view, 0, nil); err != nil {
+		t.Fatalf("the spending transaction itself must still validate: %v", err)
+	}
+	doubleSpend := types.NewTransaction()
+	doubleSpend.AddTxIn(types.NewTxInput(&tx.Tx.TxIn[0].PreviousOut, nil))
+	doubleSpend.AddTxOut(types.NewTxOutput(2, []byte{txscript.OP_TRUE}))
+
+	err := ValidateTransactionScripts(types.NewTx(doubleSpend), view, 0, nil)
+	rerr, ok := err.(RuleError)
+	if !ok || rerr.ErrorCode != ErrSpentTxOut {
+		t.Fatalf("expect ErrSpentTxOut, but %v", err)
+	}
+	if !strings.Contains(rerr.Description, tx.Hash().String()) {
+		t.Fatalf("expect the spending transaction in error: %s", rerr.Description)
+	}
+
+	// A genuinely absent output is still reported as missing.
+	view.RemoveEntry(tx.Tx.TxIn[0].PreviousOut)
+	err = ValidateTransactionScripts(types.NewTx(doubleSpend), view, 0, nil)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrMissingTxOut {
+		t.Fatalf("expect ErrMissingTxOut, but %v", err)
+	}
+}
+
+func TestValidateRedeemScriptLimit(t *testing.T) {
+	// The redeem script executes 100 operations before succeeding.
+	builder := txscript.NewScriptBuilder()
+	for i := 0; i < 100; i++ {
+		builder.AddOp(txscript.OP_NOP)
+	}
+	redeemScript, err := builder.AddOp(txscript.OP_TRUE).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToScriptHashScript(hash.Hash160(redeemScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, view := buildScriptTestTxs([][]byte{pkScript}, [][]byte{sigScript})
+
+	if err := ValidateTransactionScripts(tx, view, txscript.ScriptBip16, nil); err != nil {
+		t.Fatalf("expect the redeem script within the default limit: %v", err)
+	}
+
+	config := &ValidateConfig{MaxRedeemScriptOps: 50}
+	err = ValidateTransactionScriptsWithConfig(tx, view, txscript.ScriptBip16, nil, config)
+	rerr, ok := err.(RuleError)
+	if !ok || rerr.ErrorCode != ErrScriptValidation {
+		t.Fatalf("expect ErrScriptValidation, but %v", err)
+	}
+	if !strings.Contains(rerr.Description, txscript.ErrRedeemScriptLimit.Error()) {
+		t.Fatalf("expect the resource limit in error: %s", rerr.Description)
+	}
+
+	// The default limit is below the limit of every script.
+	builder = txscript.NewScriptBuilder()
+	for i := 0; i < DefaultMaxRedeemScriptOps+20; i++ {
+		builder.AddOp(txscript.OP_NOP)
+	}
+	longScript, err := builder.AddOp(txscript.OP_TRUE).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err = txscript.PayToScriptHashScript(hash.Hash160(longScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigScript, err = txscript.NewScriptBuilder().AddData(longScript).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	longTx, longView := buildScriptTestTxs([][]byte{pkScript}, [][]byte{sigScript})
+	err = ValidateTransactionScripts(longTx, longView, txscript.ScriptBip16, nil)
+	if err == nil || !strings.Contains(err.Error(), txscript.ErrRedeemScriptLimit.Error()) {
+		t.Fatalf("expect the default limit is exceeded, but %v", err)
+	}
+
+	// The limit only applies to redeem scripts.
+	config.MaxRedeemScriptOps = 1
+	tx, view = buildScriptTestTxs([][]byte{redeemScript}, [][]byte{nil})
+	if err := ValidateTransactionScriptsWithConfig(tx, view, txscript.ScriptBip16, nil, config); err != nil {
+		t.Fatalf("expect a plain script is not limited: %v", err)
+	}
+}
+
+// buildP2PKHTx returns a transaction with the requested number of inputs which
+// all spend signed pay-to-pubkey-hash outputs.
+func buildP2PKHTx(numInputs int) (*types.Tx, *UtxoViewpoint, error) {
+	priv, pub := ecc.Secp256k1.PrivKeyFromBytes([]byte{
+		0x2b, 0x8c, 0x52, 0xb7, 0x7b, 0x32, 0x7c, 0x75,
+		0x5b, 0x9b, 0x37, 0x55, 0x15, 0xd3, 0xf4, 0xc8,
+		0x6d, 0xa4, 0xd3, 0x7a, 0x35, 0x1a, 0x0a, 0x91,
+		0xd4, 0xf4, 0x0c, 0x19, 0x1b, 0x1d, 0xe5, 0x01})
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(hash.Hash160(pub.SerializeCompressed())).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		return nil, nil, err
+	}
+	pkScripts := make([][]byte, numInputs)
+	for i := range pkScripts {
+		pkScripts[i] = pkScript
+	}
+	tx, view := buildScriptTestTxs(pkScripts, make([][]byte, numInputs))
+	for i, txIn := range tx.Tx.TxIn {
+		sigScript, err := txscript.SignatureScript(tx.Tx, i, pkScript, txscript.SigHashAll, priv, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		txIn.SignScript = sigScript
+	}
+	return types.NewTx(tx.Tx), view, nil
+}
+
+func TestValidateWorkers(t *testing.T) {
+	tx, view, err := buildP2PKHTx(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 4} {
+		config := &ValidateConfig{Workers: workers}
+		err := ValidateTransactionScriptsWithConfig(tx, view, txscript.ScriptBip16, nil, config)
+		if err != nil {
+			t.Fatalf("workers %d: %v", workers, err)
+		}
+	}
+
+	// A single worker must still abort on the input signed for another index.
+	tx.Tx.TxIn[5].SignScript = tx.Tx.TxIn[4].SignScript
+	err = ValidateTransactionScriptsWithConfig(types.NewTx(tx.Tx), view, txscript.ScriptBip16, nil, &ValidateConfig{Workers: 1})
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrScriptValidation {
+		t.Fatalf("expect ErrScriptValidation, but %v", err)
+	}
+}
+
+func BenchmarkValidateWorkers(b *testing.B) {
+	tx, view, err := buildP2PKHTx(1000)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, runtime.NumCPU(), runtime.NumCPU() * 3} {
+		config := &ValidateConfig{Workers: workers}
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				err := ValidateTransactionScriptsWithConfig(tx, view, txscript.ScriptBip16, nil, config)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	tx, view, err := buildP2PKHTx(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := ValidateTransactionScriptsAll(tx, view, txscript.ScriptBip16, nil, nil); len(errs) != 0 {
+		t.Fatalf("expect no error, but %v", errs)
+	}
+
+	// Break two inputs by using the signatures of other inputs.
+	tx.Tx.TxIn[1].SignScript = tx.Tx.TxIn[0].SignScript
+	tx.Tx.TxIn[4].SignScript = tx.Tx.TxIn[3].SignScript
+	tx = types.NewTx(tx.Tx)
+	if err := ValidateTransactionScripts(tx, view, txscript.ScriptBip16, nil); err == nil {
+		t.Fatal("expect the fast failing validation to fail")
+	}
+	errs := ValidateTransactionScriptsAll(tx, view, txscript.ScriptBip16, nil, &ValidateConfig{Workers: 2})
+	if len(errs) != 2 {
+		t.Fatalf("expect 2 errors, but %v", errs)
+	}
+	for i, index := range []int{1, 4} {
+		rerr, ok := errs[i].(RuleError)
+		if !ok || rerr.ErrorCode != ErrScriptValidation {
+			t.Fatalf("expect ErrScriptValidation, but %v", errs[i])
+		}
+		key := fmt.Sprintf("%s:%d", tx.Hash(), index)
+		if !strings.Contains(rerr.Description, key) {
+			t.Fatalf("expect %s in error: %s", key, rerr.Description)
+		}
+	}
+}
+
+func TestSigCacheStats(t *testing.T) {
+	tx, view, err := buildP2PKHTx(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := types.NewBlock(&types.Block{
+		Header:       params.PrivNetParams.GenesisBlock.Header,
+		Transactions: []*types.Transaction{tx.Tx},
+	})
+	sigCache := txscript.NewSigCache(10)
+
+	if err := checkBlockScripts(block, view, sigCache, nil, params.PrivNetParam.Params); err != nil {
+		t.Fatal(err)
+	}
+	stats := sigCache.Stats()
+	if stats.Hits != 0 || stats.Misses != 4 || stats.Entries != 4 || stats.MaxEntries != 10 {
+		t.Fatalf("unexpected stats of the first pass: %+v", stats)
+	}
+	if err := checkBlockScripts(block, view, sigCache, nil, params.PrivNetParam.Params); err != nil {
+		t.Fatal(err)
+	}
+	stats = sigCache.Stats()
+	if stats.Hits != 4 || stats.Misses != 4 || stats.Evictions != 0 {
+		t.Fatalf("expect the second pass hits the cache: %+v", stats)
+	}
+
+	// A smaller cache evicts entries to add the new ones.
+	sigCache = txscript.NewSigCache(2)
+	if err := checkBlockScripts(block, view, sigCache, nil, params.PrivNetParam.Params); err != nil {
+		t.Fatal(err)
+	}
+	stats = sigCache.Stats()
+	if stats.Entries != 2 || stats.Evictions != 2 {
+		t.Fatalf("expect 2 entries evicted: %+v", stats)
+	}
+}
+
+func TestValidateCoinbaseMaturity(t *testing.T) {
+	tx, view := buildTrueScriptTx(1)
+	prevOut := tx.Tx.TxIn[0].PreviousOut
+	coinbaseBlock := hash.Hash{1}
+	view.addTxOut(prevOut, types.NewTxOutput(1, []byte{txscript.OP_TRUE}), true, &coinbaseBlock)
+
+	maturity := params.PrivNetParams.CoinbaseMaturity
+	heightOf := func(blockHash *hash.Hash) (uint, bool) {
+		if blockHash.IsEqual(&coinbaseBlock) {
+			return 10, true
+		}
+		return 0, false
+	}
+	config := &ValidateConfig{
+		CoinbaseMaturity: maturity,
+		BlockHeight:      10 + uint(maturity) - 1,
+		BlockHeightOf:    heightOf,
+	}
+	err := ValidateTransactionScriptsWithConfig(tx, view, 0, nil, config)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrImmatureSpend {
+		t.Fatalf("expect ErrImmatureSpend, but %v", err)
+	}
+	if errs := ValidateTransactionScriptsAll(tx, view, 0, nil, config); len(errs) != 1 {
+		t.Fatalf("expect 1 immature spend, but %v", errs)
+	}
+
+	config.BlockHeight++
+	if err := ValidateTransactionScriptsWithConfig(tx, view, 0, nil, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The output of a block whose height is unknown is missing.
+	config.BlockHeightOf = func(blockHash *hash.Hash) (uint, bool) {
+		return 0, false
+	}
+	err = ValidateTransactionScriptsWithConfig(tx, view, 0, nil, config)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrMissingTxOut {
+		t.Fatalf("expect ErrMissingTxOut, but %v", err)
+	}
+
+	// The maturity can't be checked without looking the height up.
+	config.BlockHeightOf = nil
+	err = ValidateTransactionScriptsWithConfig(tx, view, 0, nil, config)
+	if _, ok := err.(AssertError); !ok {
+		t.Fatalf("expect AssertError, but %v", err)
+	}
+
+	// Without maturity the coinbase output is spendable at once.
+	if err := ValidateTransactionScripts(tx, view, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateCtxCancel(t *testing.T) {
+	tx, view := buildTrueScriptTx(2000)
+	coinbaseBlock := hash.Hash{1}
+	for _, txIn := range tx.Tx.TxIn {
+		view.addTxOut(txIn.PreviousOut, types.NewTxOutput(1, []byte{txscript.OP_TRUE}), true, &coinbaseBlock)
+	}
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ValidateTransactionScriptsCtx(ctx, tx, view, txscript.ScriptBip16, nil, nil); err != context.Canceled {
+		t.Fatalf("expect context.Canceled before validation, but %v", err)
+	}
+
+	// The maturity check of the first input cancels the validation and
+	// holds its worker until the validation has returned, so the other
+	// inputs can't finish it first.
+	ctx, cancel = context.WithCancel(context.Background())
+	release := make(chan struct{})
+	var once sync.Once
+	config := &ValidateConfig{
+		Workers:          4,
+		CoinbaseMaturity: 1,
+		BlockHeight:      10,
+		BlockHeightOf: func(blockHash *hash.Hash) (uint, bool) {
+			once.Do(func() {
+				cancel()
+				<-release
+			})
+			return 0, true
+		},
+	}
+	err := ValidateTransactionScriptsCtx(ctx, tx, view, txscript.ScriptBip16, nil, config)
+	close(release)
+	if err != context.Canceled {
+		t.Fatalf("expect context.Canceled, but %v", err)
+	}
+
+	// The maturity of the config is enforced.
+	config.CoinbaseMaturity = 100
+	config.BlockHeightOf = func(blockHash *hash.Hash) (uint, bool) {
+		return 0, true
+	}
+	err = ValidateTransactionScriptsCtx(context.Background(), tx, view, txscript.ScriptBip16, nil, config)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrImmatureSpend {
+		t.Fatalf("expect ErrImmatureSpend, but %v", err)
+	}
+
+	// The workers exit once they finish the inputs in hand.
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 100 {
+			t.Fatalf("expect %d goroutines after cancel, but %d", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidateErrorCodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		pkScript  []byte
+		sigScript []byte
+		code      ErrorCode
+		script    bool
+	}{
+		{"missing", []byte{txscript.OP_TRUE}, nil, ErrMissingTxOut, false},
+		// The push of 5 bytes has no data.
+		{"malformed", []byte{txscript.OP_TRUE}, []byte{txscript.OP_DATA_5}, ErrScriptMalformed, true},
+		{"validation", []byte{txscript.OP_FALSE}, nil, ErrScriptValidation, true},
+	}
+	for _, test := range tests {
+		tx, view := buildScriptTestTxs([][]byte{test.pkScript}, [][]byte{test.sigScript})
+		if test.code == ErrMissingTxOut {
+			view.RemoveEntry(tx.Tx.TxIn[0].PreviousOut)
+		}
+		err := ValidateTransactionScripts(tx, view, 0, nil)
+		wrapped := fmt.Errorf("block: %w", err)
+
+		var rerr RuleError
+		if !errors.As(wrapped, &rerr) || rerr.ErrorCode != test.code {
+			t.Fatalf("%s: expect %v, but %v", test.name, test.code, err)
+		}
+		if !errors.Is(wrapped, test.code) || !errors.Is(wrapped, RuleError{ErrorCode: test.code}) {
+			t.Fatalf("%s: expect errors.Is matches %v", test.name, test.code)
+		}
+		if errors.Is(wrapped, ErrSpentTxOut) {
+			t.Fatalf("%s: expect errors.Is doesn't match another code", test.name)
+		}
+		if IsScriptError(wrapped) != test.script {
+			t.Fatalf("%s: expect IsScriptError %v", test.name, test.script)
+		}
+	}
+	if IsScriptError(nil) || IsScriptError(fmt.Errorf("failed")) {
+		t.Fatal("expect no script error")
+	}
+}
+
+func TestCheckBlockScriptsDoubleSpend(t *testing.T) {
+	tx1, view := buildTrueScriptTx(2)
+	// The second transaction spends the second output again.
+	msgTx := types.NewTransaction()
+	msgTx.AddTxIn(types.NewTxInput(&tx1.Tx.TxIn[1].PreviousOut, nil))
+	msgTx.AddTxOut(types.NewTxOutput(1, []byte{txscript.OP_TRUE}))
+	tx2 := types.NewTx(msgTx)
+
+	block := types.NewBlock(&types.Block{
+		Header:       params.PrivNetParams.GenesisBlock.Header,
+		Transactions: []*types.Transaction{tx1.Tx, tx2.Tx},
+	})
+	err := checkBlockScripts(block, view, nil, nil, params.PrivNetParam.Params)
+	if !errors.Is(err, ErrDoubleSpend) {
+		t.Fatalf("expect ErrDoubleSpend, but %v", err)
+	}
+	desc := err.(RuleError).Description
+	if !strings.Contains(desc, tx1.Hash().String()) || !strings.Contains(desc, tx2.Hash().String()) {
+		t.Fatalf("expect both transactions in the error: %s", desc)
+	}
+
+	block = types.NewBlock(&types.Block{
+		Header:       params.PrivNetParams.GenesisBlock.Header,
+		Transactions: []*types.Transaction{tx1.Tx},
+	})
+	if err := checkBlockScripts(block, view, nil, nil, params.PrivNetParam.Params); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckBlockScriptsParams(t *testing.T) {
+	// OP_SHA256 fails on the empty stack, it's a no-op before its rule is
+	// enforced.
+	tx, view := buildScriptTestTxs([][]byte{{txscript.OP_SHA256, txscript.OP_TRUE}}, [][]byte{nil})
+	block := types.NewBlock(&types.Block{
+		Header:       params.PrivNetParams.GenesisBlock.Header,
+		Transactions: []*types.Transaction{tx.Tx},
+	})
+	p := *params.PrivNetParam.Params
+	p.ScriptSHA256Height = 100
+
+	block.SetHeight(99)
+	if err := checkBlockScripts(block, view, nil, nil, &p); err != nil {
+		t.Fatalf("expect OP_SHA256 is a no-op below the activation height: %v", err)
+	}
+	block.SetHeight(100)
+	if err := checkBlockScripts(block, view, nil, nil, &p); err == nil {
+		t.Fatal("expect OP_SHA256 is enforced at the activation height")
+	}
+}
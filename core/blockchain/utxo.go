@@ -46,6 +46,7 @@ type UtxoEntry struct {
 	amount      uint64 // The amount of the output.
 	pkScript    []byte // The public key script for the output.
 	blockHash   hash.Hash
+	spentBy     *hash.Hash // The spending transaction, when spent in this view.
 	packedFlags txoFlags
 }
 
@@ -84,6 +85,13 @@ func (entry *UtxoEntry) Spend() {
 	entry.packedFlags |= tfSpent | tfModified
 }
 
+// SpentBy returns the hash of the transaction that spent the output in the
+// view it was obtained from, or nil when it is unspent or the spender is not
+// known.
+func (entry *UtxoEntry) SpentBy() *hash.Hash {
+	return entry.spentBy
+}
+
 // Amount returns the amount of the output.
 func (entry *UtxoEntry) Amount() uint64 {
 	return entry.amount
@@ -104,6 +112,7 @@ func (entry *UtxoEntry) Clone() *UtxoEntry {
 		amount:      entry.amount,
 		pkScript:    entry.pkScript,
 		blockHash:   entry.blockHash,
+		spentBy:     entry.spentBy,
 		packedFlags: entry.packedFlags,
 	}
 }
@@ -194,6 +203,7 @@ func (view *UtxoViewpoint) addTxOut(outpoint types.TxOutPoint, txOut *types.TxOu
 	entry.amount = txOut.Amount
 	entry.pkScript = txOut.PkScript
 	entry.blockHash = *blockHash
+	entry.spentBy = nil
 	entry.packedFlags = tfModified
 	if isCoinBase {
 		entry.packedFlags |= tfCoinBase
@@ -392,6 +402,7 @@ func (view *UtxoViewpoint) connectTransaction(tx *types.Tx, node *blockNode, blo
 				txIn.PreviousOut))
 		}
 		entry.Spend()
+		entry.spentBy = tx.Hash()
 
 		// Don't create the stxo details if not requested.
 		if stxos == nil {
@@ -491,6 +502,7 @@ func (view *UtxoViewpoint) disconnectTransactions(block *types.SerializedBlock,
 			entry.amount = stxo.OriAmount
 			entry.pkScript = stxo.PkScript
 			entry.blockHash = stxo.BlockHash
+			entry.spentBy = nil
 			entry.packedFlags = tfModified
 			if stxo.IsCoinBase {
 				entry.packedFlags |= tfCoinBase
@@ -758,14 +758,6 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 	if checkpoint != nil && uint64(node.GetLayer()) <= checkpoint.Layer {
 		runScripts = false
 	}
-	var scriptFlags txscript.ScriptFlags
-	var err error
-	if runScripts {
-		scriptFlags, err = b.consensusScriptVerifyFlags(node)
-		if err != nil {
-			return err
-		}
-	}
 
 	// At first, we must calculate the dag duplicate tx for block.
 	b.CalculateDAGDuplicateTxs(block)
@@ -778,7 +770,7 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 	// scripts.
 	// Do this for all TxTrees.
 
-	err = utxoView.fetchInputUtxos(b.db, block, b)
+	err := utxoView.fetchInputUtxos(b.db, block, b)
 	if err != nil {
 		return err
 	}
@@ -816,9 +808,12 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 		}
 	}
 
+	// The blocks read back from the database don't carry their height, so
+	// it's taken from the node before the script flags are resolved at it.
 	if runScripts {
-		err = checkBlockScripts(block, utxoView,
-			scriptFlags, b.sigCache)
+		block.SetHeight(node.GetHeight())
+		err = checkBlockScripts(block, utxoView, b.sigCache,
+			b.blockValidateConfig(node), b.params)
 		if err != nil {
 			log.Trace("checkBlockScripts failed; error returned "+
 				"on txtreeregular of cur block: %v", err)
@@ -829,12 +824,28 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 	return nil
 }
 
-// consensusScriptVerifyFlags returns the script flags that must be used when
-// executing transaction scripts to enforce the consensus rules. This includes
-// any flags required as the result of any agendas that have passed and become
-// active.
-func (b *BlockChain) consensusScriptVerifyFlags(node *blockNode) (txscript.ScriptFlags, error) {
-	//TODO, refactor the txvm flag, the flag should decided by node.parent
+// blockValidateConfig returns the script validation config of the chain with
+// the per-block fields filled in for the block of the passed node.
+func (b *BlockChain) blockValidateConfig(node *blockNode) *ValidateConfig {
+	config := ValidateConfig{}
+	if b.validateConfig != nil {
+		config = *b.validateConfig
+	}
+	config.BlockHeight = node.GetHeight()
+	config.BlockHeightOf = func(blockHash *hash.Hash) (uint, bool) {
+		origin := b.index.LookupNode(blockHash)
+		if origin == nil {
+			return 0, false
+		}
+		return origin.GetHeight(), true
+	}
+	return &config
+}
+
+// ScriptFlagsForHeight returns the script flags of consensus for the block at
+// the given height of main chain, the script rules of the params are enforced
+// from their activation heights.
+func ScriptFlagsForHeight(height uint64, params *params.Params) txscript.ScriptFlags {
 	scriptFlags := txscript.ScriptBip16 |
 		txscript.ScriptVerifyDERSignatures |
 		txscript.ScriptVerifyStrictEncoding |
@@ -842,9 +853,13 @@ func (b *BlockChain) consensusScriptVerifyFlags(node *blockNode) (txscript.Scrip
 		txscript.ScriptVerifyCleanStack |
 		txscript.ScriptVerifyCheckLockTimeVerify
 
-	scriptFlags |= txscript.ScriptVerifyCheckSequenceVerify
-	scriptFlags |= txscript.ScriptVerifySHA256
-	return scriptFlags, nil
+	if height >= params.ScriptCSVHeight {
+		scriptFlags |= txscript.ScriptVerifyCheckSequenceVerify
+	}
+	if height >= params.ScriptSHA256Height {
+		scriptFlags |= txscript.ScriptVerifySHA256
+	}
+	return scriptFlags
 }
 
 // checkTransactionsAndConnect is the local function used to check the
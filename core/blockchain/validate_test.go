@@ -3,9 +3,12 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
+	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"testing"
+	"time"
 )
 
 func Test_CheckTransactionSanity(t *testing.T) {
@@ -65,3 +68,54 @@ func checkTransactionSanityForAllNet(tx *types.Transaction) error {
 	}
 	return nil
 }
+
+func Test_ScriptFlagsForHeight(t *testing.T) {
+	p := *params.PrivNetParam.Params
+	allFlags := ScriptFlagsForHeight(0, &p)
+	if allFlags&txscript.ScriptVerifySHA256 == 0 ||
+		allFlags&txscript.ScriptVerifyCheckSequenceVerify == 0 {
+		t.Fatalf("default params should enforce all script rules, got %v", allFlags)
+	}
+
+	p.ScriptSHA256Height = 100
+	if flags := ScriptFlagsForHeight(99, &p); flags != allFlags&^txscript.ScriptVerifySHA256 {
+		t.Fatalf("height 99: got %v, want %v", flags, allFlags&^txscript.ScriptVerifySHA256)
+	}
+	if flags := ScriptFlagsForHeight(100, &p); flags != allFlags {
+		t.Fatalf("height 100: got %v, want %v", flags, allFlags)
+	}
+}
+
+func Test_BlockValidateConfig(t *testing.T) {
+	b := &BlockChain{
+		index:          newBlockIndex(nil, params.PrivNetParam.Params),
+		validateConfig: &ValidateConfig{CoinbaseMaturity: 16, BlockHeight: 1},
+	}
+	newNode := func(height uint) *blockNode {
+		header := params.PrivNetParam.GenesisBlock.Header
+		header.Timestamp = time.Unix(int64(height), 0)
+		node := newBlockNode(&header, nil)
+		node.SetHeight(height)
+		b.index.AddNode(node)
+		return node
+	}
+	origin := newNode(10)
+	node := newNode(30)
+
+	config := b.blockValidateConfig(node)
+	if config.CoinbaseMaturity != 16 || config.BlockHeight != 30 {
+		t.Fatalf("expect maturity 16 at height 30, but %d at %d", config.CoinbaseMaturity, config.BlockHeight)
+	}
+	if height, ok := config.BlockHeightOf(origin.GetHash()); !ok || height != 10 {
+		t.Fatalf("expect the origin at height 10, but %d", height)
+	}
+	if _, ok := config.BlockHeightOf(&hash.Hash{}); ok {
+		t.Fatal("expect an unknown block has no height")
+	}
+	if b.validateConfig.BlockHeight != 1 || b.validateConfig.BlockHeightOf != nil {
+		t.Fatal("expect the chain config is not changed")
+	}
+	if config := (&BlockChain{index: b.index}).blockValidateConfig(node); config.BlockHeight != 30 {
+		t.Fatalf("expect height 30 without chain config, but %d", config.BlockHeight)
+	}
+}
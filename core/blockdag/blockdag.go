@@ -47,6 +47,9 @@ const MaxTipLayerGap = 10
 // StableConfirmations
 const StableConfirmations = 10
 
+// The default maximum number of blocks an epoch of Conflux may depend on
+const MaxEpochDepends = 10000
+
 // It will create different BlockDAG instances
 func NewBlockDAG(dagType string) IBlockDAG {
 	switch dagType {
@@ -187,6 +190,10 @@ type BlockDAG struct {
 	getBlockId GetBlockId
 
 	db database.DB
+
+	// The results of ancestor queries, it is only used after being enabled
+	// by EnableAncestorCache.
+	ancestorCache map[[2]uint]bool
 }
 
 // Acquire the name of DAG instance
@@ -221,9 +228,27 @@ func (bd *BlockDAG) AddBlock(b IBlockData) (*list.List, IBlock) {
 	bd.stateLock.Lock()
 	defer bd.stateLock.Unlock()
 
-	if b == nil {
+	lastTime := bd.lastTime
+	ib := bd.insertBlock(b)
+	if ib == nil {
+		return nil, nil
+	}
+	l := bd.instance.AddBlock(ib)
+	// The block that can't be ordered may have been unlinked again, then its
+	// time doesn't count either.
+	if bd.blocks[ib.GetID()] != ib {
+		bd.lastTime = lastTime
 		return nil, nil
 	}
+	return l, ib
+}
+
+// Link the block into DAG without ordering it, nil is returned if the block
+// can't be added.
+func (bd *BlockDAG) insertBlock(b IBlockData) IBlock {
+	if b == nil {
+		return nil
+	}
 	// Must keep no block in outside.
 	/*	if bd.hasBlock(b.GetHash()) {
 		return nil
@@ -232,18 +257,20 @@ func (bd *BlockDAG) AddBlock(b IBlockData) (*list.List, IBlock) {
 	if bd.blockTotal > 0 {
 		parentsIds := b.GetParents()
 		if len(parentsIds) == 0 {
-			return nil, nil
+			return nil
 		}
+		// A block with an unknown parent is rejected before anything is
+		// linked, the ordering can't handle it.
 		for _, v := range parentsIds {
 			pib := bd.getBlockById(v)
 			if pib == nil {
-				return nil, nil
+				return nil
 			}
 			parents = append(parents, pib)
 		}
 
 		if !bd.isDAG(parents) {
-			return nil, nil
+			return nil
 		}
 	}
 	//
@@ -286,7 +313,31 @@ func (bd *BlockDAG) AddBlock(b IBlockData) (*list.List, IBlock) {
 		bd.lastTime = t
 	}
 	//
-	return bd.instance.AddBlock(ib), ib
+	return ib
+}
+
+// Unlink a block without children from DAG. Its id is left unused rather
+// than taken by the blocks added after it, so the loops over ids must skip
+// the missing ones.
+func (bd *BlockDAG) removeBlock(ib IBlock) {
+	id := ib.GetID()
+	if ib.HasParents() {
+		for _, pid := range ib.GetParents().List() {
+			parent := bd.getBlockById(pid)
+			if parent == nil {
+				continue
+			}
+			parent.GetChildren().Remove(id)
+			if !parent.HasChildren() {
+				bd.tips.AddPair(pid, parent)
+			}
+		}
+	}
+	bd.tips.Remove(id)
+	delete(bd.blocks, id)
+	if bd.ancestorCache != nil {
+		bd.ancestorCache = map[[2]uint]bool{}
+	}
 }
 
 // Acquire the genesis block of chain
@@ -343,6 +394,20 @@ func (bd *BlockDAG) GetBlock(h *hash.Hash) IBlock {
 	return bd.getBlock(h)
 }
 
+// Acquire one block by hash, ok is false if the block is unknown. The hashes
+// supplied by peers should be queried by it.
+func (bd *BlockDAG) GetBlockOK(h *hash.Hash) (IBlock, bool) {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	return bd.getBlockOK(h)
+}
+
+func (bd *BlockDAG) getBlockOK(h *hash.Hash) (IBlock, bool) {
+	ib := bd.getBlock(h)
+	return ib, ib != nil
+}
+
 // Acquire one block by hash
 // Be careful, this is inefficient and cannot be called frequently
 func (bd *BlockDAG) getBlock(h *hash.Hash) IBlock {
@@ -434,6 +499,46 @@ func (bd *BlockDAG) updateTips(b IBlock) {
 	bd.tips.AddPair(b.GetID(), b)
 }
 
+// Save the current tips, so the restarted node can restore them without
+// scanning all blocks.
+func (bd *BlockDAG) SaveTips(dbTx database.Tx) error {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	if bd.tips == nil || bd.tips.IsEmpty() {
+		return fmt.Errorf("no tips")
+	}
+	return DBPutDAGTips(dbTx, bd.tips.List())
+}
+
+// Restore the tips that was saved. Every tip must be a known block
+// and have no children.
+func (bd *BlockDAG) LoadTips(dbTx database.Tx) error {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	ids, err := DBGetDAGTips(dbTx)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no tips")
+	}
+	tips := NewIdSet()
+	for _, id := range ids {
+		ib := bd.getBlockById(id)
+		if ib == nil {
+			return fmt.Errorf("tip (%d) is not in the dag", id)
+		}
+		if ib.HasChildren() {
+			return fmt.Errorf("tip (%d) has children", id)
+		}
+		tips.AddPair(id, ib)
+	}
+	bd.tips = tips
+	return nil
+}
+
 // The last time is when add one block to DAG.
 func (bd *BlockDAG) GetLastTime() *time.Time {
 	bd.stateLock.Lock()
@@ -442,7 +547,8 @@ func (bd *BlockDAG) GetLastTime() *time.Time {
 	return &bd.lastTime
 }
 
-// Return the full sequence array.
+// Return the full sequence array. The orders compacted by the instance are
+// missing from it.
 func (bd *BlockDAG) GetOrder() map[uint]uint {
 	bd.stateLock.Lock()
 	defer bd.stateLock.Unlock()
@@ -480,8 +586,12 @@ func (bd *BlockDAG) GetPrevious(id uint) (uint, error) {
 	if b.GetOrder() == 0 {
 		return 0, fmt.Errorf("no pre")
 	}
-	// TODO
-	return bd.order[b.GetOrder()-1], nil
+	// The orders compacted by the instance are dropped from the order.
+	pre, ok := bd.order[b.GetOrder()-1]
+	if !ok {
+		return 0, fmt.Errorf("no pre, the order %d has been compacted", b.GetOrder()-1)
+	}
+	return pre, nil
 }
 
 // Returns a future collection of block. This function is a recursively called function
@@ -500,6 +610,85 @@ func (bd *BlockDAG) getFutureSet(fs *IdSet, b IBlock) {
 	}
 }
 
+// Query whether a block is the ancestor of another block, a block is not the
+// ancestor of itself.
+func (bd *BlockDAG) IsAncestor(ancestor, descendant *hash.Hash) bool {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	a, ok := bd.getBlockOK(ancestor)
+	if !ok {
+		return false
+	}
+	d, ok := bd.getBlockOK(descendant)
+	if !ok {
+		return false
+	}
+	return bd.isAncestor(a, d)
+}
+
+// Query whether a block is the descendant of another block.
+func (bd *BlockDAG) IsDescendant(descendant, ancestor *hash.Hash) bool {
+	return bd.IsAncestor(ancestor, descendant)
+}
+
+// Cache the results of ancestor queries. The cache remains valid as blocks
+// are added, since a new block never changes the past of existing blocks.
+func (bd *BlockDAG) EnableAncestorCache(enable bool) {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	if enable {
+		if bd.ancestorCache == nil {
+			bd.ancestorCache = map[[2]uint]bool{}
+		}
+	} else {
+		bd.ancestorCache = nil
+	}
+}
+
+func (bd *BlockDAG) isAncestor(a IBlock, d IBlock) bool {
+	if a.GetID() == d.GetID() || a.GetLayer() >= d.GetLayer() {
+		return false
+	}
+	key := [2]uint{a.GetID(), d.GetID()}
+	if bd.ancestorCache != nil {
+		if result, ok := bd.ancestorCache[key]; ok {
+			return result
+		}
+	}
+	// Search the past of descendant, which can't be deeper than the layer
+	// of ancestor.
+	result := false
+	visited := NewIdSet()
+	queue := []IBlock{d}
+	for len(queue) > 0 && !result {
+		cur := queue[0]
+		queue = queue[1:]
+		if !cur.HasParents() {
+			continue
+		}
+		for k, v := range cur.GetParents().GetMap() {
+			if k == a.GetID() {
+				result = true
+				break
+			}
+			if visited.Has(k) {
+				continue
+			}
+			visited.Add(k)
+			parent := v.(IBlock)
+			if parent.GetLayer() > a.GetLayer() {
+				queue = append(queue, parent)
+			}
+		}
+	}
+	if bd.ancestorCache != nil {
+		bd.ancestorCache[key] = result
+	}
+	return result
+}
+
 // Query whether a given block is on the main chain.
 // Note that some DAG protocols may not support this feature.
 func (bd *BlockDAG) IsOnMainChain(id uint) bool {
@@ -1,10 +1,14 @@
 package blockdag
 
 import (
+	"container/heap"
 	"container/list"
+	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/database"
 	"io"
+	"sort"
+	"sync"
 )
 
 type Epoch struct {
@@ -35,6 +39,16 @@ func (e *Epoch) HasBlock(h *hash.Hash) bool {
 	return false
 }
 
+// The block of main chain that the epoch is built on
+func (e *Epoch) GetMain() IBlock {
+	return e.main
+}
+
+// The blocks ordered before the main block of epoch
+func (e *Epoch) GetDepends() []IBlock {
+	return e.depends
+}
+
 func (e *Epoch) HasDepends() bool {
 	if e.depends == nil {
 		return false
@@ -50,64 +64,528 @@ type Conflux struct {
 	bd *BlockDAG
 
 	privotTip IBlock
+
+	// The number of blocks rolled back from the main chain by the most
+	// recent reorganization and by the deepest one observed.
+	lastReorgDepth int
+	maxReorgDepth  int
+
+	// The orders below it have been finalized and dropped from the order
+	// of DAG by CompactOrder.
+	orderBase uint
+
+	// The virtual block that merges all tips after the pivot tip, it only
+	// exists when there are multiple tips.
+	virtualTip *Block
+
+	// The sum of the anticone sizes of all blocks. A new block is in the
+	// anticone of every block of its own anticone, so it adds the size of
+	// its anticone twice. The number of blocks summed up is counted along,
+	// since the blocks of DAG are added outside of the lock.
+	anticoneTotal uint
+	anticoneCount uint
+
+	// The size of the past of every block, a new block takes it from its
+	// main parent.
+	pastSizes map[uint]uint
+
+	// The weights of blocks on the tree of main parents
+	weights weightTree
+
+	// The epochs are checked again when the next main block may change, the
+	// weights get one heavier at most for each block added. The number of
+	// blocks added when the check of every epoch main block is due is kept,
+	// the checks are queued by it.
+	addedBlocks uint64
+	mainChecks  map[uint]uint64
+	checkQueue  checkHeap
+
+	// The rolling checksums of order, the one at each order covers all the
+	// blocks up to it.
+	orderChecksums []hash.Hash
+
+	// The maximum number of blocks an epoch may depend on
+	maxEpochDepends int
+
+	observers []OrderObserver
+
+	// It's called with the blocks that left and joined the main chain
+	// when the main chain is reorganized.
+	reorgCallback func(removed, added []*hash.Hash)
+
+	// It guards the state of Conflux against the readers of other
+	// goroutines, the readers hold it for reading and the writers for
+	// writing. The blocks of DAG are written outside of it, so GetOrder,
+	// GetBlockByOrder and GetMainChain only read the views below, which are
+	// updated with the order under the lock. The other methods walking the
+	// blocks take the state lock of DAG first, which BlockDAG.AddBlock holds
+	// while the blocks are written, except the ones of IBlockDAG that are
+	// called by BlockDAG with it held. Reading the weights reshapes their
+	// tree, so it's held for writing to read them.
+	lock sync.RWMutex
+
+	// The hashes of blocks from orderBase and the main chain from genesis to
+	// pivot tip by ids and by hashes, as they were after the last change of
+	// order. The heights of the main chain blocks are kept by id to find
+	// where a new pivot tip forks from the main chain.
+	orderView        []*hash.Hash
+	mainChainView    []uint
+	mainChainHashes  []*hash.Hash
+	mainChainHeights map[uint]int
+
+	// The epochs of main chain in order, it is rebuilt with the order.
+	epochs []*Epoch
+
+	// Order all blocks from genesis for every new block instead of only
+	// the changed part of main chain.
+	fullReorder bool
+
+	// The orders before it are kept by the current update of main chain.
+	reorderFrom uint
+
+	// The decisions of ordering are written to it when it's set.
+	orderTrace io.Writer
+}
+
+// OrderObserver is notified when blocks enter or leave the order of Conflux,
+// so that the layers built on the order can follow reorganizations. It is
+// called while DAG and Conflux are locked, so it must not call back into them,
+// even GetOrder.
+type OrderObserver interface {
+	// The block enters the order at the given position
+	OnConnect(order uint, h *hash.Hash)
+
+	// The block leaves the given position of the order
+	OnDisconnect(order uint, h *hash.Hash)
+}
+
+// OrderError describes an inconsistency of the order of Conflux.
+type OrderError struct {
+	Order  uint
+	Block  *hash.Hash
+	Reason string
+}
+
+func (e *OrderError) Error() string {
+	if e.Block == nil {
+		return fmt.Sprintf("Order %d: %s", e.Order, e.Reason)
+	}
+	return fmt.Sprintf("Order %d (%s): %s", e.Order, e.Block, e.Reason)
+}
+
+// The aggregate state of Conflux for monitoring.
+type ConfluxStats struct {
+	OrderLen        uint
+	MainChainHeight int
+	TipCount        int
+	PendingCount    int
+	MaxReorgDepth   int
+	LastReorgDepth  int
+	AverageAnticone float64
 }
 
 func (con *Conflux) GetName() string {
 	return conflux
 }
 
+// Initialize with the DAG, which is usually empty. If it has blocks, its
+// genesis must be the only block without parents.
 func (con *Conflux) Init(bd *BlockDAG) bool {
+	if bd == nil {
+		log.Error("Conflux can't be initialized without DAG")
+		return false
+	}
 	con.bd = bd
+	con.maxEpochDepends = MaxEpochDepends
+	if bd.blockTotal == 0 {
+		return true
+	}
+	genesis := bd.getGenesis()
+	if genesis == nil || genesis.HasParents() || !genesis.GetHash().IsEqual(&bd.genesis) {
+		log.Error("The genesis of DAG is invalid")
+		return false
+	}
+	for id, block := range bd.blocks {
+		if id != genesis.GetID() && !block.HasParents() {
+			log.Error(fmt.Sprintf("The block %s has no parents, but it isn't genesis", block.GetHash()))
+			return false
+		}
+	}
+	con.buildWeights()
 	return true
 }
 
+// Limit the number of blocks an epoch may depend on, the block causing an
+// epoch to exceed it can't be ordered.
+func (con *Conflux) SetMaxEpochDepends(max int) {
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	con.maxEpochDepends = max
+}
+
 func (con *Conflux) AddBlock(b IBlock) *list.List {
 	if b == nil {
 		return nil
 	}
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	if con.bd.getGenesis() == nil {
+		log.Error(fmt.Sprintf("Can't order block %s without genesis", b.GetHash()))
+		return nil
+	}
 	//
-	con.updatePrivot(b)
-	oldOrder := con.bd.order
-	con.bd.order = map[uint]uint{}
-	con.updateMainChain(con.bd.getGenesis(), nil, nil)
+	err := con.updatePrivot(b)
+	if err != nil {
+		log.Error(fmt.Sprintf("Can't order block %s: %s", b.GetHash(), err))
+		con.unlinkBlock(b)
+		return nil
+	}
+	con.addedBlocks++
+	// Only the epochs from the first one whose main block may be changed are
+	// ordered again, the order before it is kept.
+	start, preEpoch, epochIndex := con.getReorderStart(b)
+	var cut uint
+	if preEpoch != nil {
+		cut = preEpoch.main.GetOrder() + 1
+	}
+	if con.bd.order == nil {
+		con.bd.order = map[uint]uint{}
+	}
+	oldOrder := map[uint]uint{}
+	for o := cut; o < con.bd.blockTotal; o++ {
+		if id, ok := con.bd.order[o]; ok {
+			oldOrder[o] = id
+			delete(con.bd.order, o)
+		}
+	}
+	oldPrivotTip := con.privotTip
+	oldVirtualTip := con.virtualTip
+	oldEpochs := append([]*Epoch{}, con.epochs[epochIndex:]...)
+	con.reorderFrom = cut
+	// The new block isn't ordered yet, it must not be taken as a kept one.
+	b.SetOrder(MaxBlockOrder)
+	con.virtualTip = nil
+	con.epochs = con.epochs[:epochIndex]
+	err = con.updateMainChain(start, preEpoch, nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("Can't order block %s: %s", b.GetHash(), err))
+		for o := cut; o < con.bd.blockTotal; o++ {
+			delete(con.bd.order, o)
+		}
+		con.privotTip = oldPrivotTip
+		con.virtualTip = oldVirtualTip
+		con.epochs = append(con.epochs[:epochIndex], oldEpochs...)
+		b.SetOrder(MaxBlockOrder)
+		for order, id := range oldOrder {
+			con.bd.order[order] = id
+			con.bd.getBlockById(id).SetOrder(order)
+		}
+		con.unlinkBlock(b)
+		// The checks may have been queued by the weights with the block.
+		con.resetChecks()
+		return nil
+	}
+	for o := cut; o < con.orderBase; o++ {
+		delete(con.bd.order, o)
+	}
+	con.scheduleChecks(epochIndex - 1)
+	con.addAnticone(b)
+	con.recoverPrivotTip()
+	removed, added := con.updateMainChainView()
+	con.updateReorgDepth(len(removed))
+	if len(removed) > 0 && con.reorgCallback != nil {
+		con.reorgCallback(removed, added)
+	}
 
 	var result *list.List
-	var i uint
-	for i = 0; i < con.bd.blockTotal; i++ {
+	var i, first uint
+	i = con.orderBase
+	if cut > i {
+		i = cut
+	}
+	for ; i < con.bd.blockTotal; i++ {
+		id, ok := con.bd.order[i]
+		if !ok {
+			break
+		}
 		if result == nil {
-			if len(oldOrder) == 0 ||
-				i >= uint(len(oldOrder)) ||
-				oldOrder[i] != con.bd.order[i] {
+			if old, ok := oldOrder[i]; !ok || old != id {
 				result = list.New()
-				result.PushBack(con.bd.order[i])
+				result.PushBack(id)
+				con.updateOrderChecksum(i)
+				first = i
 			}
 		} else {
-			result.PushBack(con.bd.order[i])
+			result.PushBack(id)
 		}
 
 	}
+	if result != nil {
+		con.notifyOrderObservers(oldOrder, first)
+	} else {
+		first = con.bd.blockTotal
+	}
+	con.updateView(first)
 	return result
 }
 
+// Update the views of order from the given order, it's called with the lock
+// held after the order has been changed.
+func (con *Conflux) updateView(from uint) {
+	if from < con.orderBase {
+		from = con.orderBase
+	}
+	if from-con.orderBase < uint(len(con.orderView)) {
+		con.orderView = con.orderView[:from-con.orderBase]
+	}
+	for o := con.orderBase + uint(len(con.orderView)); o < con.bd.blockTotal; o++ {
+		id, ok := con.bd.order[o]
+		if !ok {
+			break
+		}
+		con.orderView = append(con.orderView, con.bd.getBlockById(id).GetHash())
+	}
+}
+
+// Update the views of main chain after the pivot tip has been changed, and
+// return the blocks that left it from the previous pivot tip backward and the
+// ones that joined it from the fork forward. The main chain is only walked
+// from the pivot tip back to the first block still on the views, the views
+// are kept up to that fork.
+func (con *Conflux) updateMainChainView() ([]*hash.Hash, []*hash.Hash) {
+	if con.mainChainHeights == nil {
+		con.mainChainHeights = map[uint]int{}
+	}
+	joined := []IBlock{}
+	fork := -1
+	for p := con.privotTip; p != nil; p = con.bd.getBlockById(p.GetMainParent()) {
+		if height, ok := con.mainChainHeights[p.GetID()]; ok {
+			fork = height
+			break
+		}
+		joined = append(joined, p)
+	}
+	removed := []*hash.Hash{}
+	for i := len(con.mainChainView) - 1; i > fork; i-- {
+		removed = append(removed, con.mainChainHashes[i])
+		delete(con.mainChainHeights, con.mainChainView[i])
+	}
+	con.mainChainView = con.mainChainView[:fork+1]
+	con.mainChainHashes = con.mainChainHashes[:fork+1]
+	added := make([]*hash.Hash, 0, len(joined))
+	for i := len(joined) - 1; i >= 0; i-- {
+		con.mainChainHeights[joined[i].GetID()] = len(con.mainChainView)
+		con.mainChainView = append(con.mainChainView, joined[i].GetID())
+		con.mainChainHashes = append(con.mainChainHashes, joined[i].GetHash())
+		added = append(added, joined[i].GetHash())
+	}
+	return removed, added
+}
+
+// Return the hashes of blocks in order from orderBase, the blocks only
+// ordered by the virtual block are at the end.
+func (con *Conflux) GetOrder() []*hash.Hash {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return append([]*hash.Hash{}, con.orderView...)
+}
+
+// Return the block to order the main chain again from, along with the epoch
+// before it and the index of its epoch. The next main block of an epoch can
+// only change if the new block is a child of its main block or the check of
+// the epoch is due, so only those epochs are compared with the next ones. The
+// epochs before the first different one stay the same since the new block
+// can't be their ancestor.
+func (con *Conflux) getReorderStart(b IBlock) (IBlock, *Epoch, int) {
+	if con.fullReorder || len(con.epochs) == 0 {
+		return con.bd.getGenesis(), nil, 0
+	}
+	var start IBlock
+	index := len(con.epochs)
+	check := func(main IBlock) {
+		i := con.epochIndex(main)
+		if i < 0 || i+1 >= len(con.epochs) {
+			return
+		}
+		nextMain := con.getNextMain(main)
+		if nextMain.GetID() == con.epochs[i+1].main.GetID() {
+			con.scheduleCheck(i)
+		} else if i+1 < index {
+			start, index = nextMain, i+1
+		}
+	}
+	if b.HasParents() {
+		for id := range b.GetParents().GetMap() {
+			if parent := con.bd.getBlockById(id); parent != nil {
+				check(parent)
+			}
+		}
+	}
+	for con.checkQueue.Len() > 0 && con.checkQueue[0].due <= con.addedBlocks {
+		c := heap.Pop(&con.checkQueue).(mainCheck)
+		if due, ok := con.mainChecks[c.main.GetID()]; !ok || due != c.due {
+			continue
+		}
+		delete(con.mainChecks, c.main.GetID())
+		check(c.main)
+	}
+	if start != nil {
+		return start, con.epochs[index-1], index
+	}
+	last := len(con.epochs) - 1
+	if last == 0 {
+		return con.bd.getGenesis(), nil, 0
+	}
+	// The last epoch is on the pivot tip, it's ordered again since the
+	// new block may be its child or only be merged by the virtual block.
+	return con.epochs[last].main, con.epochs[last-1], last
+}
+
+// Set the callback that is told which blocks left and joined the main chain
+// when it's reorganized, it isn't called when the main chain is only extended.
+// It is called while DAG and Conflux are locked, so it must not call back into
+// them, even GetOrder.
+func (con *Conflux) SetReorgCallback(callback func(removed, added []*hash.Hash)) {
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	con.reorgCallback = callback
+}
+
+// Write each decision of ordering to w for debugging: the children competing
+// for the main chain with their weights, the winner and the rule that picked
+// it, and the depends of epoch ordered by block id. The main chain is checked
+// again for every new block, so a decision may be written more than once.
+// Nil turns it off.
+func (con *Conflux) SetOrderTrace(w io.Writer) {
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	con.orderTrace = w
+}
+
+// Register an observer of the changes of order.
+func (con *Conflux) AddOrderObserver(observer OrderObserver) {
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	con.observers = append(con.observers, observer)
+}
+
+// Tell the observers that the old order from the given order is replaced. The
+// old blocks leave from the last one, then the new blocks enter from the first.
+func (con *Conflux) notifyOrderObservers(oldOrder map[uint]uint, from uint) {
+	if len(con.observers) == 0 {
+		return
+	}
+	oldHashes := map[uint]*hash.Hash{}
+	for o, id := range oldOrder {
+		oldHashes[o] = con.bd.getBlockById(id).GetHash()
+	}
+	con.notifyOrderChange(oldHashes, from)
+}
+
+// Tell the observers that the old order given by the hashes is replaced, for
+// the old blocks that may have left DAG.
+func (con *Conflux) notifyOrderChange(oldOrder map[uint]*hash.Hash, from uint) {
+	if len(con.observers) == 0 {
+		return
+	}
+	var end uint
+	for o := range oldOrder {
+		if o+1 > end {
+			end = o + 1
+		}
+	}
+	for o := end; o > from; o-- {
+		h, ok := oldOrder[o-1]
+		if !ok {
+			continue
+		}
+		for _, observer := range con.observers {
+			observer.OnDisconnect(o-1, h)
+		}
+	}
+	for o := from; o < con.bd.blockTotal; o++ {
+		id, ok := con.bd.order[o]
+		if !ok {
+			continue
+		}
+		h := con.bd.getBlockById(id).GetHash()
+		for _, observer := range con.observers {
+			observer.OnConnect(o, h)
+		}
+	}
+}
+
+// Recompute the rolling checksums of order from the given order, the ones
+// before it are still valid.
+func (con *Conflux) updateOrderChecksum(from uint) {
+	if from < uint(len(con.orderChecksums)) {
+		con.orderChecksums = con.orderChecksums[:from]
+	}
+	for i := uint(len(con.orderChecksums)); i < con.bd.blockTotal; i++ {
+		id, ok := con.bd.order[i]
+		if !ok {
+			break
+		}
+		var data [hash.HashSize * 2]byte
+		if i > 0 {
+			copy(data[:hash.HashSize], con.orderChecksums[i-1][:])
+		}
+		copy(data[hash.HashSize:], con.bd.getBlockById(id).GetHash()[:])
+		con.orderChecksums = append(con.orderChecksums, hash.HashH(data[:]))
+	}
+}
+
+// Return the checksum of the whole order, two nodes with the same order have
+// the same checksum.
+func (con *Conflux) OrderChecksum() []byte {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	if len(con.orderChecksums) == 0 {
+		return nil
+	}
+	checksum := con.orderChecksums[len(con.orderChecksums)-1]
+	return checksum[:]
+}
+
 // Build self block
 func (con *Conflux) CreateBlock(b *Block) IBlock {
 	return b
 }
 
+// Return the tips with the pivot tip first, it's called by BlockDAG with the
+// state lock held. If the pivot tip is out of sync with the tips, the highest
+// tip is put first instead, the pivot tip is selected again by the next change
+// of DAG.
 func (con *Conflux) GetTipsList() []IBlock {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.getTipsList()
+}
+
+func (con *Conflux) getTipsList() []IBlock {
 	if con.bd.tips.IsEmpty() || con.privotTip == nil {
 		return nil
 	}
-	if con.bd.tips.HasOnly(con.privotTip.GetID()) {
-		return []IBlock{con.privotTip}
+	first := con.privotTip
+	if !con.bd.tips.Has(first.GetID()) {
+		log.Warn(fmt.Sprintf("Pivot tip %s is not in tips", first.GetHash()))
+		first = con.highestTip()
 	}
-	if !con.bd.tips.Has(con.privotTip.GetID()) {
-		return nil
+	if con.bd.tips.HasOnly(first.GetID()) {
+		return []IBlock{first}
 	}
 	tips := con.bd.tips.Clone()
-	tips.Remove(con.privotTip.GetID())
+	tips.Remove(first.GetID())
 	//tipsList := tips.List()
-	result := []IBlock{con.privotTip}
+	result := []IBlock{first}
 	for _, v := range tips.GetMap() {
 		ib := v.(IBlock)
 		result = append(result, ib)
@@ -115,49 +593,518 @@ func (con *Conflux) GetTipsList() []IBlock {
 	return result
 }
 
-func (con *Conflux) updatePrivot(b IBlock) {
+// Link a new block to its main parent in the tree of weights. If the main
+// parent isn't the main parent of any other block, it and all its main
+// ancestors get one heavier.
+func (con *Conflux) updatePrivot(b IBlock) error {
 	if b.GetMainParent() == MaxId {
-		return
+		con.weights.link(b.GetID(), MaxId, 0)
+		return nil
 	}
 	parent := con.bd.getBlockById(b.GetMainParent())
-	var newWeight uint64 = 0
-	for h := range parent.GetChildren().GetMap() {
-		block := con.bd.getBlockById(h)
-		if block.GetMainParent() == parent.GetID() {
-			newWeight += block.GetWeight()
+	if parent == nil {
+		return fmt.Errorf("The main parent (%d) of %s is unknown", b.GetMainParent(), b.GetHash())
+	}
+	con.weights.link(b.GetID(), parent.GetID(), 0)
+	if !con.hasMainChild(parent, b) {
+		con.weights.addPath(parent.GetID(), 1)
+	}
+	return nil
+}
+
+// Unlink a block that has been removed from DAG from the tree of weights. Its
+// main parent weighs zero again if it isn't the main parent of any other
+// block.
+func (con *Conflux) removeWeight(b IBlock) {
+	con.weights.cut(b.GetID())
+	parent := con.bd.getBlockById(b.GetMainParent())
+	if parent == nil {
+		return
+	}
+	if !con.hasMainChild(parent, b) {
+		con.weights.addPath(parent.GetID(), -1)
+	}
+}
+
+// Whether the block is the main parent of any child other than the given one.
+func (con *Conflux) hasMainChild(b IBlock, except IBlock) bool {
+	if !b.HasChildren() {
+		return false
+	}
+	for id := range b.GetChildren().GetMap() {
+		if id != except.GetID() && con.bd.getBlockById(id).GetMainParent() == b.GetID() {
+			return true
+		}
+	}
+	return false
+}
+
+func (con *Conflux) getWeight(b IBlock) uint64 {
+	return con.weights.get(b.GetID())
+}
+
+// Build the tree of weights of all blocks from scratch.
+func (con *Conflux) buildWeights() {
+	con.weights.reset()
+	weights := con.computeWeights()
+	// A child always has a larger id than its parents.
+	for id := uint(0); id < con.bd.blockTotal; id++ {
+		if block := con.bd.getBlockById(id); block != nil {
+			con.weights.link(id, block.GetMainParent(), weights[id])
+		}
+	}
+}
+
+// Queue the checks of the epochs from the given index again.
+func (con *Conflux) scheduleChecks(from int) {
+	if from < 0 {
+		from = 0
+	}
+	for i := from; i+1 < len(con.epochs); i++ {
+		con.scheduleCheck(i)
+	}
+}
+
+// Queue the check of all epochs again, after the weights have been changed
+// other than by adding a block.
+func (con *Conflux) resetChecks() {
+	con.mainChecks = nil
+	con.checkQueue = nil
+	con.scheduleChecks(0)
+}
+
+// Queue the check of an epoch for when its next main block may change. Another
+// child of its main block must get as heavy as the next main block first, and
+// the block with the smaller hash wins a tie.
+func (con *Conflux) scheduleCheck(i int) {
+	if con.fullReorder {
+		return
+	}
+	if con.mainChecks == nil {
+		con.mainChecks = map[uint]uint64{}
+	}
+	main := con.epochs[i].main
+	nextMain := con.epochs[i+1].main
+	weight := con.getWeight(nextMain)
+	var margin uint64
+	others := false
+	for id := range main.GetChildren().GetMap() {
+		if id == nextMain.GetID() {
+			continue
+		}
+		m := uint64(1)
+		if w := con.weights.get(id); w+1 < weight {
+			m = weight - w
+		}
+		if !others || m < margin {
+			margin = m
+		}
+		others = true
+	}
+	if !others {
+		// The next main block is the only child, the new children of the
+		// main block are checked when they are added.
+		delete(con.mainChecks, main.GetID())
+		return
+	}
+	due := con.addedBlocks + margin
+	con.mainChecks[main.GetID()] = due
+	heap.Push(&con.checkQueue, mainCheck{main: main, due: due})
+}
+
+// The check of the next main block of an epoch, it's due when the given
+// number of blocks have been added.
+type mainCheck struct {
+	main IBlock
+	due  uint64
+}
+
+type checkHeap []mainCheck
+
+func (h checkHeap) Len() int           { return len(h) }
+func (h checkHeap) Less(i, j int) bool { return h[i].due < h[j].due }
+func (h checkHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *checkHeap) Push(x interface{}) {
+	*h = append(*h, x.(mainCheck))
+}
+
+func (h *checkHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Unlink a block that can't be ordered from DAG and restore the weights of
+// its main ancestors, so DAG is left as if the block had never been added.
+func (con *Conflux) unlinkBlock(b IBlock) {
+	con.bd.removeBlock(b)
+	con.removeWeight(b)
+	// It's the last block linked and its id has never been handed out, so
+	// the next block takes the id again.
+	con.bd.blockTotal = b.GetID()
+	con.recoverPrivotTip()
+	con.updateMainChainView()
+}
+
+// Compute the weights of all blocks from scratch, as the tree keeps them:
+// a block that is the main parent of others weighs one more than the sum of
+// their weights, and the other blocks weigh zero.
+func (con *Conflux) computeWeights() map[uint]uint64 {
+	weights := map[uint]uint64{}
+	// A child always has a larger id than its parents.
+	for id := con.bd.blockTotal; id > 0; id-- {
+		block := con.bd.getBlockById(id - 1)
+		if block == nil || block.GetMainParent() == MaxId {
+			continue
 		}
+		mainParent := block.GetMainParent()
+		if _, ok := weights[mainParent]; !ok {
+			weights[mainParent] = 1
+		}
+		weights[mainParent] += weights[id-1]
+	}
+	return weights
+}
+
+// Check the weights kept by the tree against the ones computed from scratch,
+// the first block with a different weight is reported. Reading the tree
+// reshapes it, so the lock is held for writing.
+func (con *Conflux) VerifyWeights() error {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.Lock()
+	defer con.lock.Unlock()
 
+	weights := con.computeWeights()
+	for id := uint(0); id < con.bd.blockTotal; id++ {
+		block := con.bd.getBlockById(id)
+		if block == nil {
+			continue
+		}
+		if weight := con.getWeight(block); weight != weights[id] {
+			return fmt.Errorf("The weight of block %s (%d) is %d, but %d is computed", block.GetHash(), id, weight, weights[id])
+		}
 	}
-	parent.SetWeight(newWeight + 1)
-	if parent.GetMainParent() != MaxId {
-		con.updatePrivot(parent)
+	return nil
+}
+
+// Load blocks into an empty DAG with the order exported along with them. The
+// weights are computed in one pass, and the given order is trusted instead of
+// ordering the blocks again for every block as AddBlock does. It's checked in
+// one pass, and all the blocks are ordered from genesis if it's wrong. DAG is
+// left empty if the blocks can't be loaded.
+func (con *Conflux) LoadOrdered(blocks []IBlockData, order []*hash.Hash) error {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	if con.bd.blockTotal > 0 {
+		return fmt.Errorf("Only blocks can be loaded into an empty DAG")
+	}
+	if len(blocks) == 0 {
+		return nil
 	}
+	loaded := make([]IBlock, 0, len(blocks))
+	for _, data := range blocks {
+		block := con.bd.insertBlock(data)
+		if block == nil {
+			con.unload(loaded)
+			return fmt.Errorf("Can't load block %s", data.GetHash())
+		}
+		loaded = append(loaded, block)
+	}
+	con.buildWeights()
+	err := con.setOrder(loaded, order)
+	if err != nil {
+		if order != nil {
+			log.Warn(fmt.Sprintf("The loaded order is wrong, use the computed order: %s", err))
+		}
+		err = con.reorderAll()
+		if err != nil {
+			con.unload(loaded)
+			return err
+		}
+	}
+	// The main chain is known now, so the past of most blocks is taken from
+	// their main parents.
+	for _, block := range loaded {
+		con.addAnticone(block)
+	}
+	con.updateOrderChecksum(0)
+	con.updateView(0)
+	con.updateMainChainView()
+	con.resetChecks()
+	con.notifyOrderObservers(map[uint]uint{}, 0)
+	return nil
+}
+
+// Unlink the loaded blocks from the last one, so DAG is empty again.
+func (con *Conflux) unload(loaded []IBlock) {
+	for i := len(loaded) - 1; i >= 0; i-- {
+		con.bd.removeBlock(loaded[i])
+	}
+	con.bd.blockTotal = 0
+	con.bd.order = map[uint]uint{}
+	con.anticoneTotal = 0
+	con.anticoneCount = 0
+	con.pastSizes = nil
+	con.epochs = nil
+	con.privotTip = nil
+	con.virtualTip = nil
+	con.weights.reset()
+	con.resetChecks()
+	con.updateMainChainView()
 }
 
-func (con *Conflux) updateMainChain(b IBlock, preEpoch *Epoch, main *HashSet) {
+// Set the given order of the loaded blocks and rebuild the epochs from it,
+// instead of ordering the blocks again. It's checked to be the order that
+// updateMainChain computes: the main chain picked by the weights splits it
+// into epochs, every epoch holds the blocks in the past of its main block
+// that the epochs before it don't hold, and they're ordered by the round
+// getForwardBlocks forwards them in and then by id.
+func (con *Conflux) setOrder(loaded []IBlock, order []*hash.Hash) error {
+	if len(order) != len(loaded) {
+		return fmt.Errorf("The order has %d of %d blocks", len(order), len(loaded))
+	}
+	blocks := make(map[hash.Hash]IBlock, len(loaded))
+	for _, block := range loaded {
+		blocks[*block.GetHash()] = block
+		block.SetOrder(MaxBlockOrder)
+	}
+	mains := []IBlock{con.bd.getGenesis()}
+	for b := mains[0]; b.HasChildren(); {
+		b = con.getNextMain(b)
+		mains = append(mains, b)
+	}
+	con.bd.order = map[uint]uint{}
+	con.epochs = nil
+	con.reorderFrom = 0
+	con.virtualTip = nil
+
+	// The rounds of the depends of the current epoch, and the depends that
+	// aren't known to be in the past of its main block yet.
+	rounds := map[uint]int{}
+	unknown := NewIdSet()
+	var depends []IBlock
+	lastRound, lastID := -1, uint(0)
+	next := 0
+	for i, h := range order {
+		o := uint(i)
+		block, ok := blocks[*h]
+		if !ok {
+			return &OrderError{Order: o, Block: h, Reason: "the block isn't loaded"}
+		}
+		if block.GetOrder() != MaxBlockOrder {
+			return &OrderError{Order: o, Block: h, Reason: "the block is at multiple orders"}
+		}
+		round := 0
+		if block.HasParents() {
+			for id, v := range block.GetParents().GetMap() {
+				parent := v.(IBlock)
+				if parent.GetOrder() > o {
+					return &OrderError{Order: o, Block: h, Reason: fmt.Sprintf("the parent %s is after it", parent.GetHash())}
+				}
+				if r, ok := rounds[id]; ok {
+					if r+1 > round {
+						round = r + 1
+					}
+					unknown.Remove(id)
+				}
+			}
+		}
+		block.SetOrder(o)
+		con.bd.order[o] = block.GetID()
+		if next < len(mains) && block.GetID() == mains[next].GetID() {
+			if !unknown.IsEmpty() {
+				return &OrderError{Order: o, Block: h, Reason: "the epoch holds blocks out of the past of it"}
+			}
+			con.epochs = append(con.epochs, &Epoch{main: block, depends: depends})
+			rounds = map[uint]int{}
+			depends = nil
+			lastRound = -1
+			next++
+			continue
+		}
+		if round < lastRound || (round == lastRound && block.GetID() < lastID) {
+			return &OrderError{Order: o, Block: h, Reason: "the block is out of the order of its epoch"}
+		}
+		lastRound, lastID = round, block.GetID()
+		rounds[block.GetID()] = round
+		unknown.Add(block.GetID())
+		depends = append(depends, block)
+		if con.maxEpochDepends > 0 && len(depends) > con.maxEpochDepends {
+			return &OrderError{Order: o, Block: h, Reason: fmt.Sprintf("the epoch depends on more than %d blocks", con.maxEpochDepends)}
+		}
+	}
+	con.privotTip = mains[len(mains)-1]
+	// The blocks after the pivot tip are merged by the virtual block.
+	if con.bd.tips.Size() > 1 {
+		virtualBlock := Block{hash: hash.Hash{}, weight: 1, order: uint(len(order))}
+		virtualBlock.parents = NewIdSet()
+		virtualBlock.parents.AddSet(con.bd.tips)
+		con.virtualTip = &virtualBlock
+	}
+	for o := uint(0); o < con.orderBase; o++ {
+		delete(con.bd.order, o)
+	}
+	return nil
+}
+
+// Order all blocks again from genesis, the orders before orderBase are
+// dropped again.
+func (con *Conflux) reorderAll() error {
+	for _, block := range con.bd.blocks {
+		block.SetOrder(MaxBlockOrder)
+	}
+	con.bd.order = map[uint]uint{}
+	con.epochs = nil
+	con.reorderFrom = 0
+	con.virtualTip = nil
+	err := con.updateMainChain(con.bd.getGenesis(), nil, nil)
+	if err != nil {
+		return err
+	}
+	for o := uint(0); o < con.orderBase; o++ {
+		delete(con.bd.order, o)
+	}
+	return nil
+}
+
+// Remove a block that turned out to be invalid. Only a block without children
+// can be removed, so the descendants must be removed first. Its id isn't
+// reused, so the ids of the other blocks don't change.
+// All blocks are ordered again, and the observers and the reorganization
+// callback are told the changes as AddBlock does.
+func (con *Conflux) RemoveBlock(h *hash.Hash) error {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	b, ok := con.bd.getBlockOK(h)
+	if !ok {
+		return fmt.Errorf("The block %s isn't in DAG", h)
+	}
+	if b.GetID() == con.bd.getGenesis().GetID() {
+		return fmt.Errorf("The genesis %s can't be removed", h)
+	}
+	if b.HasChildren() {
+		return fmt.Errorf("The block %s has children, they must be removed first", h)
+	}
+	if b.GetOrder() < con.orderBase {
+		return fmt.Errorf("The order of block %s has been compacted", h)
+	}
+	oldOrder := map[uint]*hash.Hash{}
+	for o, id := range con.bd.order {
+		oldOrder[o] = con.bd.getBlockById(id).GetHash()
+	}
+
+	con.removeAnticone(b)
+	con.bd.removeBlock(b)
+	con.removeWeight(b)
+	err := con.reorderAll()
+	if err != nil {
+		return err
+	}
+	con.resetChecks()
+
+	removed, added := con.updateMainChainView()
+	con.updateReorgDepth(len(removed))
+	if len(removed) > 0 && con.reorgCallback != nil {
+		con.reorgCallback(removed, added)
+	}
+	first := con.orderBase
+	for ; first < con.bd.blockTotal; first++ {
+		old, ok := oldOrder[first]
+		id, ordered := con.bd.order[first]
+		if !ok || !ordered || !old.IsEqual(con.bd.getBlockById(id).GetHash()) {
+			break
+		}
+	}
+	con.updateOrderChecksum(first)
+	con.updateView(first)
+	con.notifyOrderChange(oldOrder, first)
+	return nil
+}
+
+func (con *Conflux) updateMainChain(b IBlock, preEpoch *Epoch, main *HashSet) error {
 	if main == nil {
 		main = NewHashSet()
 	}
 	main.Add(b.GetHash())
 
-	curEpoch := con.updateOrder(b, preEpoch, main)
+	curEpoch, err := con.updateOrder(b, preEpoch, main)
+	if err != nil {
+		return err
+	}
 	if con.isVirtualBlock(b) {
-		return
+		return nil
 	}
+	con.epochs = append(con.epochs, curEpoch)
 	if !b.HasChildren() {
 		con.privotTip = b
 		if con.bd.tips.Size() > 1 {
 			virtualBlock := Block{hash: hash.Hash{}, weight: 1}
 			virtualBlock.parents = NewIdSet()
 			virtualBlock.parents.AddSet(con.bd.tips)
-			con.updateMainChain(&virtualBlock, curEpoch, main)
+			con.virtualTip = &virtualBlock
+			return con.updateMainChain(&virtualBlock, curEpoch, main)
 		}
-		return
+		return nil
 	}
+	nextMain := con.getNextMain(b)
+	if nextMain != nil {
+		return con.updateMainChain(nextMain, curEpoch, main)
+	}
+	return nil
+}
+
+// Select up to max tips to build a new block on, the pivot tip is always the
+// first one. The others are ordered by weight, a tip only has weight if it has
+// been the main parent of others, so the higher layer and then the smaller id
+// win a tie as selectPrivotTip does.
+func (con *Conflux) SelectTips(max int) []*hash.Hash {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	if con.bd.blockTotal == 0 {
+		return nil
+	}
+	tips := con.getTipsList()
+	if len(tips) == 0 || max <= 0 {
+		return nil
+	}
+	others := tips[1:]
+	sort.Slice(others, func(i, j int) bool {
+		if wi, wj := con.getWeight(others[i]), con.getWeight(others[j]); wi != wj {
+			return wi > wj
+		}
+		if others[i].GetLayer() != others[j].GetLayer() {
+			return others[i].GetLayer() > others[j].GetLayer()
+		}
+		return others[i].GetID() < others[j].GetID()
+	})
+	if len(tips) > max {
+		tips = tips[:max]
+	}
+	result := make([]*hash.Hash, len(tips))
+	for i, tip := range tips {
+		result[i] = tip.GetHash()
+	}
+	return result
+}
+
+// Select the child of block that the main chain goes through, it is the
+// heaviest one and the smaller hash wins a tie.
+func (con *Conflux) getNextMain(b IBlock) IBlock {
 	children := b.GetChildren().SortList(false)
 	if len(children) == 1 {
-		con.updateMainChain(con.bd.getBlockById(children[0]), curEpoch, main)
-		return
+		return con.bd.getBlockById(children[0])
 	}
 	var nextMain IBlock = nil
 	for _, h := range children {
@@ -166,9 +1113,9 @@ func (con *Conflux) updateMainChain(b IBlock, preEpoch *Epoch, main *HashSet) {
 		if nextMain == nil {
 			nextMain = child
 		} else {
-			if child.GetWeight() > nextMain.GetWeight() {
+			if con.getWeight(child) > con.getWeight(nextMain) {
 				nextMain = child
-			} else if child.GetWeight() == nextMain.GetWeight() {
+			} else if con.getWeight(child) == con.getWeight(nextMain) {
 				if child.GetHash().String() < nextMain.GetHash().String() {
 					nextMain = child
 				}
@@ -176,27 +1123,427 @@ func (con *Conflux) updateMainChain(b IBlock, preEpoch *Epoch, main *HashSet) {
 		}
 
 	}
-	if nextMain != nil {
-		con.updateMainChain(nextMain, curEpoch, main)
+	if con.orderTrace != nil && nextMain != nil {
+		con.traceNextMain(b, children, nextMain)
+	}
+	return nextMain
+}
+
+// Write the children of block competing for the main chain and the rule that
+// selected the next main block.
+func (con *Conflux) traceNextMain(b IBlock, children []uint, nextMain IBlock) {
+	rule := "heaviest"
+	fmt.Fprintf(con.orderTrace, "pivot %s:", b.GetHash())
+	for _, id := range children {
+		child := con.bd.getBlockById(id)
+		fmt.Fprintf(con.orderTrace, " %s(weight %d)", child.GetHash(), con.getWeight(child))
+		if id != nextMain.GetID() && con.getWeight(child) == con.getWeight(nextMain) {
+			rule = "smallest hash of equal weight"
+		}
+	}
+	fmt.Fprintf(con.orderTrace, " -> %s by %s\n", nextMain.GetHash(), rule)
+}
+
+// Select the pivot tip again if it's out of sync with the tips of DAG.
+func (con *Conflux) recoverPrivotTip() {
+	if con.privotTip == nil || con.bd.tips.IsEmpty() || con.bd.tips.Has(con.privotTip.GetID()) {
+		return
+	}
+	log.Warn(fmt.Sprintf("Pivot tip %s is not in tips, recompute it", con.privotTip.GetHash()))
+	con.privotTip = con.selectPrivotTip()
+}
+
+// Recompute the pivot tip from the current tips. The main chain is followed
+// from genesis first, if it doesn't end at a tip the highest tip is chosen.
+func (con *Conflux) selectPrivotTip() IBlock {
+	if con.bd.tips.IsEmpty() {
+		return nil
+	}
+	b := con.bd.getGenesis()
+	for b != nil && b.HasChildren() {
+		b = con.getNextMain(b)
+	}
+	if b != nil && con.bd.tips.Has(b.GetID()) {
+		return b
+	}
+	return con.highestTip()
+}
+
+// Return the tip of the highest layer, the smaller id wins a tie. The tips
+// are never main parents, so they all weigh zero.
+func (con *Conflux) highestTip() IBlock {
+	var result IBlock
+	for _, id := range con.bd.tips.SortList(false) {
+		tip := con.bd.getBlockById(id)
+		if result == nil || tip.GetLayer() > result.GetLayer() {
+			result = tip
+		}
+	}
+	return result
+}
+
+// Whether the current order is using a virtual block to merge multiple tips.
+func (con *Conflux) HasVirtualTip() bool {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.virtualTip != nil
+}
+
+// Return the tips merged by the virtual block, sorted by block id.
+func (con *Conflux) VirtualTipParents() []*hash.Hash {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	if con.virtualTip == nil {
+		return nil
 	}
+	result := []*hash.Hash{}
+	for _, id := range con.virtualTip.parents.SortList(false) {
+		result = append(result, con.bd.getBlockById(id).GetHash())
+	}
+	return result
 }
 
+// Return the main chain from pivot tip backward to genesis.
 func (con *Conflux) GetMainChain() []uint {
-	result := []uint{}
-	for p := con.privotTip; p != nil; p = con.bd.getBlockById(p.GetMainParent()) {
-		result = append(result, p.GetID())
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	result := make([]uint, len(con.mainChainView))
+	for i, id := range con.mainChainView {
+		result[len(result)-1-i] = id
+	}
+	return result
+}
+
+// Record the number of blocks rolled back from the previous main chain.
+func (con *Conflux) updateReorgDepth(depth int) {
+	if depth == 0 {
+		return
+	}
+	con.lastReorgDepth = depth
+	if depth > con.maxReorgDepth {
+		con.maxReorgDepth = depth
+	}
+}
+
+// The number of blocks rolled back from the main chain by the most recent reorganization.
+func (con *Conflux) LastReorgDepth() int {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.lastReorgDepth
+}
+
+// The largest number of blocks ever rolled back from the main chain.
+func (con *Conflux) MaxReorgDepth() int {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.maxReorgDepth
+}
+
+// Return the main chain block at the given height, the height of genesis is zero.
+func (con *Conflux) MainChainBlockAt(height int) (*hash.Hash, bool) {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	if height < 0 || height >= len(con.mainChainHashes) {
+		return nil, false
+	}
+	return con.mainChainHashes[height], true
+}
+
+// The height of pivot tip on the main chain, the height of genesis is zero.
+func (con *Conflux) MainChainHeight() int {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return len(con.mainChainView) - 1
+}
+
+// The average anticone size of all blocks in DAG.
+func (con *Conflux) AverageAnticoneSize() float64 {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.averageAnticoneSize()
+}
+
+func (con *Conflux) averageAnticoneSize() float64 {
+	if con.anticoneCount == 0 {
+		return 0
+	}
+	return float64(con.anticoneTotal) / float64(con.anticoneCount)
+}
+
+// Add the anticone of a new block to the sum, it has no children yet so its
+// anticone is every other block outside of its past.
+func (con *Conflux) addAnticone(b IBlock) {
+	if con.pastSizes == nil {
+		con.pastSizes = map[uint]uint{}
+	}
+	size := con.getPastSize(b)
+	con.pastSizes[b.GetID()] = size
+	con.anticoneTotal += 2 * (con.anticoneCount - size)
+	con.anticoneCount++
+}
+
+// Take the anticone of a block without children from the sum.
+func (con *Conflux) removeAnticone(b IBlock) {
+	con.anticoneCount--
+	con.anticoneTotal -= 2 * (con.anticoneCount - con.pastSizes[b.GetID()])
+	delete(con.pastSizes, b.GetID())
+}
+
+// Return the size of the past of an ordered block. The past of the main block
+// of an epoch is everything ordered before it, so the past of a block is the
+// past of the latest main block in it and that main block, with the ancestors
+// ordered after it. The ancestors are visited from the latest order, a parent
+// is always ordered before its children, so they're all visited before the
+// main block is reached.
+func (con *Conflux) getPastSize(b IBlock) uint {
+	if !b.HasParents() {
+		return 0
+	}
+	visited := NewIdSet()
+	queue := &orderHeap{}
+	for id, v := range b.GetParents().GetMap() {
+		visited.Add(id)
+		heap.Push(queue, v.(IBlock))
+	}
+	size := uint(0)
+	for queue.Len() > 0 {
+		cur := heap.Pop(queue).(IBlock)
+		if con.isEpochMain(cur) {
+			return size + 1 + con.pastSizes[cur.GetID()]
+		}
+		size++
+		if !cur.HasParents() {
+			continue
+		}
+		for id, v := range cur.GetParents().GetMap() {
+			if !visited.Has(id) {
+				visited.Add(id)
+				heap.Push(queue, v.(IBlock))
+			}
+		}
+	}
+	return size
+}
+
+// The blocks with the latest order first
+type orderHeap []IBlock
+
+func (h orderHeap) Len() int           { return len(h) }
+func (h orderHeap) Less(i, j int) bool { return h[i].GetOrder() > h[j].GetOrder() }
+func (h orderHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *orderHeap) Push(x interface{}) {
+	*h = append(*h, x.(IBlock))
+}
+
+func (h *orderHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Whether the block is the main block of an epoch.
+func (con *Conflux) isEpochMain(b IBlock) bool {
+	return con.epochIndex(b) >= 0
+}
+
+// Return the index of the epoch whose main block is the block, or -1 if it's
+// not a main block. The epochs are in order.
+func (con *Conflux) epochIndex(b IBlock) int {
+	i := sort.Search(len(con.epochs), func(i int) bool {
+		return con.epochs[i].main.GetOrder() >= b.GetOrder()
+	})
+	if i < len(con.epochs) && con.epochs[i].main == b {
+		return i
+	}
+	return -1
+}
+
+// Return the blocks that are neither the ancestors nor the descendants of
+// the given block. The block itself and the virtual block are excluded.
+func (con *Conflux) Anticone(h *hash.Hash) (*HashSet, error) {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	b, ok := con.bd.getBlockOK(h)
+	if !ok {
+		return nil, fmt.Errorf("No block %s", h)
+	}
+	related := NewIdSet()
+	related.AddPair(b.GetID(), b)
+	con.collectRelated(related, b, blockParents)
+	con.collectRelated(related, b, blockChildren)
+
+	result := NewHashSet()
+	for id, block := range con.bd.blocks {
+		if !related.Has(id) {
+			result.AddPair(block.GetHash(), block)
+		}
+	}
+	return result, nil
+}
+
+// Return all the ancestors of the given block, the block itself is excluded.
+func (con *Conflux) GetAncestors(h *hash.Hash) (*HashSet, error) {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.getReachable(h, blockParents)
+}
+
+// Return all the descendants of the given block, the block itself and the
+// virtual block are excluded.
+func (con *Conflux) GetDescendants(h *hash.Hash) (*HashSet, error) {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.getReachable(h, blockChildren)
+}
+
+func (con *Conflux) getReachable(h *hash.Hash, next func(IBlock) *IdSet) (*HashSet, error) {
+	b, ok := con.bd.getBlockOK(h)
+	if !ok {
+		return nil, fmt.Errorf("No block %s", h)
+	}
+	reachable := NewIdSet()
+	con.collectRelated(reachable, b, next)
+	result := NewHashSet()
+	for _, v := range reachable.GetMap() {
+		ib := v.(IBlock)
+		result.AddPair(ib.GetHash(), ib)
+	}
+	return result, nil
+}
+
+func blockParents(b IBlock) *IdSet {
+	return b.GetParents()
+}
+
+func blockChildren(b IBlock) *IdSet {
+	return b.GetChildren()
+}
+
+// Add all the blocks that can be reached from b through the next sets in
+// breadth first order, it isn't recursive so a deep DAG is fine.
+func (con *Conflux) collectRelated(related *IdSet, b IBlock, next func(IBlock) *IdSet) {
+	queue := []IBlock{b}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		nexts := next(cur)
+		if nexts == nil {
+			continue
+		}
+		for id, v := range nexts.GetMap() {
+			if related.Has(id) {
+				continue
+			}
+			ib := v.(IBlock)
+			related.AddPair(id, ib)
+			queue = append(queue, ib)
+		}
+	}
+}
+
+// Collect the statistics of Conflux, the main chain is read from its view and
+// the anticone sizes are summed up as blocks are added.
+func (con *Conflux) Stats() ConfluxStats {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return ConfluxStats{
+		OrderLen:        con.orderBase + uint(len(con.bd.order)),
+		MainChainHeight: len(con.mainChainView) - 1,
+		TipCount:        con.bd.tips.Size(),
+		PendingCount:    len(con.pendingBlocks()),
+		MaxReorgDepth:   con.maxReorgDepth,
+		LastReorgDepth:  con.lastReorgDepth,
+		AverageAnticone: con.averageAnticoneSize(),
+	}
+}
+
+// Return the blocks that have not been confirmed into the order by the main
+// chain yet. They are either absent from the order or only ordered through
+// the virtual block that merges the other tips after the pivot tip.
+func (con *Conflux) PendingBlocks() []*hash.Hash {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.pendingBlocks()
+}
+
+func (con *Conflux) pendingBlocks() []*hash.Hash {
+	result := []*hash.Hash{}
+	if con.privotTip == nil {
+		return result
+	}
+	for i := uint(0); i < con.bd.blockTotal; i++ {
+		block := con.bd.getBlockById(i)
+		if block == nil {
+			continue
+		}
+		if block.GetOrder() < con.orderBase {
+			continue
+		}
+		id, ok := con.bd.order[block.GetOrder()]
+		if ok && id == block.GetID() && block.GetOrder() <= con.privotTip.GetOrder() {
+			continue
+		}
+		result = append(result, block.GetHash())
+	}
+	return result
+}
+
+// Return the epochs of main chain in order, each one holds its main block and
+// the ordered blocks it depends on. The epoch of virtual block is excluded,
+// the blocks only ordered by it are returned by PendingBlocks.
+func (con *Conflux) GetOrderedEpochs() []*Epoch {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	result := []*Epoch{}
+	for _, e := range con.epochs {
+		if e.main.GetOrder() < con.orderBase {
+			continue
+		}
+		result = append(result, e)
 	}
 	return result
 }
 
-func (con *Conflux) updateOrder(b IBlock, preEpoch *Epoch, main *HashSet) *Epoch {
+func (con *Conflux) updateOrder(b IBlock, preEpoch *Epoch, main *HashSet) (*Epoch, error) {
 
 	var result *Epoch
 	if preEpoch == nil {
 		b.SetOrder(0)
 		result = &Epoch{main: b}
 	} else {
-		result = con.getEpoch(b, preEpoch, main)
+		var err error
+		result, err = con.getEpoch(b, preEpoch, main)
+		if err != nil {
+			return nil, err
+		}
 		var dependsNum uint = 0
 		if result.HasDepends() {
 			dependsNum = uint(len(result.depends))
@@ -214,6 +1561,13 @@ func (con *Conflux) updateOrder(b IBlock, preEpoch *Epoch, main *HashSet) *Epoch
 						break
 					}
 					fbs := con.getForwardBlocks(es)
+					if con.orderTrace != nil && len(fbs) > 1 {
+						fmt.Fprintf(con.orderTrace, "epoch %s:", b.GetHash())
+						for _, fb := range fbs {
+							fmt.Fprintf(con.orderTrace, " %s", fb.GetHash())
+						}
+						fmt.Fprintf(con.orderTrace, " -> by smallest block id\n")
+					}
 					for _, fb := range fbs {
 						order++
 						fb.SetOrder(preEpoch.main.GetOrder() + uint(order))
@@ -228,20 +1582,73 @@ func (con *Conflux) updateOrder(b IBlock, preEpoch *Epoch, main *HashSet) *Epoch
 	}
 	//update list
 	sequence := result.GetSequence()
-	startOrder := len(con.bd.order)
+	var startOrder uint
+	if preEpoch != nil {
+		startOrder = preEpoch.main.GetOrder() + 1
+	}
+	if preEpoch != nil {
+		id, ok := con.bd.order[startOrder-1]
+		if ok && id != preEpoch.main.GetID() {
+			return nil, &OrderError{Order: startOrder - 1, Block: preEpoch.main.GetHash(), Reason: "the previous epoch is not at the order"}
+		}
+	}
 	for i, block := range sequence {
-		if block.GetOrder() != uint(startOrder+i) {
-			panic("epoch order error")
+		order := startOrder + uint(i)
+		if block.GetOrder() != order {
+			return nil, &OrderError{Order: order, Block: block.GetHash(), Reason: fmt.Sprintf("the block of epoch has order %d", block.GetOrder())}
+		}
+		if id, ok := con.bd.order[order]; ok && id != block.GetID() {
+			return nil, &OrderError{Order: order, Block: block.GetHash(), Reason: fmt.Sprintf("the order is taken by block (%d)", id)}
 		}
 		if !con.isVirtualBlock(block) {
 			con.bd.order[block.GetOrder()] = block.GetID()
 		}
 	}
 
-	return result
+	return result, nil
+}
+
+// Check that the order is consistent: every order after the compacted ones
+// holds one block whose order is the same, and every ordered block is held by
+// exactly one order. The block failed to be ordered is ignored.
+func (con *Conflux) ValidateOrder() error {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	seen := NewIdSet()
+	end := con.orderBase + uint(len(con.bd.order))
+	for order := con.orderBase; order < end; order++ {
+		id, ok := con.bd.order[order]
+		if !ok {
+			return &OrderError{Order: order, Reason: "no block is at the order"}
+		}
+		block := con.bd.getBlockById(id)
+		if block == nil {
+			return &OrderError{Order: order, Reason: fmt.Sprintf("the block (%d) is unknown", id)}
+		}
+		if seen.Has(id) {
+			return &OrderError{Order: order, Block: block.GetHash(), Reason: "the block is at multiple orders"}
+		}
+		seen.Add(id)
+		if block.GetOrder() != order {
+			return &OrderError{Order: order, Block: block.GetHash(), Reason: fmt.Sprintf("the block has order %d", block.GetOrder())}
+		}
+	}
+	for id := uint(0); id < con.bd.blockTotal; id++ {
+		block := con.bd.getBlockById(id)
+		if block == nil || block.GetOrder() == MaxBlockOrder || block.GetOrder() < con.orderBase {
+			continue
+		}
+		if !seen.Has(id) {
+			return &OrderError{Order: block.GetOrder(), Block: block.GetHash(), Reason: "the block is not in the order"}
+		}
+	}
+	return nil
 }
 
-func (con *Conflux) getEpoch(b IBlock, preEpoch *Epoch, main *HashSet) *Epoch {
+func (con *Conflux) getEpoch(b IBlock, preEpoch *Epoch, main *HashSet) (*Epoch, error) {
 
 	result := Epoch{main: b}
 	var dependsS *HashSet
@@ -259,8 +1666,12 @@ func (con *Conflux) getEpoch(b IBlock, preEpoch *Epoch, main *HashSet) *Epoch {
 		if block.HasParents() {
 			ids := block.GetParents().SortList(false)
 			for _, id := range ids {
-				h := con.bd.getBlockById(id).GetHash()
-				if main.Has(h) || preEpoch.HasBlock(h) {
+				parent := con.bd.getBlockById(id)
+				if parent == nil {
+					return nil, fmt.Errorf("The parent (%d) of %s is unknown", id, block.GetHash())
+				}
+				h := parent.GetHash()
+				if main.Has(h) || preEpoch.HasBlock(h) || con.isOrdered(parent) {
 					continue
 				}
 				if result.depends == nil {
@@ -270,14 +1681,16 @@ func (con *Conflux) getEpoch(b IBlock, preEpoch *Epoch, main *HashSet) *Epoch {
 				if dependsS.Has(h) {
 					continue
 				}
-				parent := con.bd.getBlockById(id)
 				result.depends = append(result.depends, parent)
+				if con.maxEpochDepends > 0 && len(result.depends) > con.maxEpochDepends {
+					return nil, fmt.Errorf("The epoch of %s depends on more than %d blocks", b.GetHash(), con.maxEpochDepends)
+				}
 				chain.PushBack(parent)
 				dependsS.Add(h)
 			}
 		}
 	}
-	return &result
+	return &result, nil
 }
 
 func (con *Conflux) getForwardBlocks(bs *IdSet) []IBlock {
@@ -325,29 +1738,106 @@ func (con *Conflux) getForwardBlocks(bs *IdSet) []IBlock {
 	return result
 }
 
+// Whether the block has been ordered by the current update of main chain.
+// The kept orders are included even though they have been compacted.
+func (con *Conflux) isOrdered(b IBlock) bool {
+	if b.GetOrder() < con.reorderFrom {
+		return true
+	}
+	id, ok := con.bd.order[b.GetOrder()]
+	return ok && id == b.GetID()
+}
+
 func (con *Conflux) isVirtualBlock(b IBlock) bool {
 	return b.GetHash().IsEqual(&hash.Hash{})
 }
 
 func (con *Conflux) GetBlockByOrder(order uint) *hash.Hash {
+	h, _ := con.GetBlockByOrderOK(order)
+	return h
+}
+
+// Return the block hash at the given order, the compacted orders and the
+// order of virtual block are not found.
+func (con *Conflux) GetBlockByOrderOK(order uint) (*hash.Hash, bool) {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
 
-	if order >= con.bd.blockTotal {
+	if order < con.orderBase || order-con.orderBase >= uint(len(con.orderView)) {
+		return nil, false
+	}
+	return con.orderView[order-con.orderBase], true
+}
+
+// Return the order of the given block, it's still found after the order
+// has been compacted.
+func (con *Conflux) GetOrderOfBlock(h *hash.Hash) (uint, bool) {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	b, ok := con.bd.getBlockOK(h)
+	if !ok || b.GetOrder() >= con.bd.blockTotal {
+		return 0, false
+	}
+	return b.GetOrder(), true
+}
+
+// Drop the finalized orders below beforeOrder to reclaim memory, they can't
+// be queried by GetBlockByOrder any more. Only the orders up to the pivot tip
+// are final, because the ones after it may still be rearranged.
+func (con *Conflux) CompactOrder(beforeOrder uint) error {
+	con.bd.stateLock.Lock()
+	defer con.bd.stateLock.Unlock()
+	con.lock.Lock()
+	defer con.lock.Unlock()
+
+	if con.privotTip == nil {
+		return fmt.Errorf("No ordered blocks")
+	}
+	if beforeOrder > con.privotTip.GetOrder() {
+		return fmt.Errorf("The order %d is not finalized, pivot tip order is %d", beforeOrder, con.privotTip.GetOrder())
+	}
+	if beforeOrder <= con.orderBase {
 		return nil
 	}
-	return con.bd.getBlockById(con.bd.order[order]).GetHash()
+	con.dropOrder(beforeOrder)
+	con.orderView = append([]*hash.Hash{}, con.orderView[beforeOrder-con.orderBase:]...)
+	con.orderBase = beforeOrder
+	return nil
 }
 
-// Query whether a given block is on the main chain.
-func (con *Conflux) IsOnMainChain(b IBlock) bool {
-	for p := con.privotTip; p != nil; p = con.bd.getBlockById(p.GetMainParent()) {
-		if p.GetHash().IsEqual(b.GetHash()) {
-			return true
-		}
-		if p.GetLayer() < b.GetLayer() {
-			break
+// The first order that is still kept in the order of DAG.
+func (con *Conflux) OrderBase() uint {
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	return con.orderBase
+}
+
+func (con *Conflux) dropOrder(beforeOrder uint) {
+	if beforeOrder == 0 {
+		return
+	}
+	for order := range con.bd.order {
+		if order < beforeOrder {
+			delete(con.bd.order, order)
 		}
 	}
-	return false
+}
+
+// Query whether a given block is on the main chain. It's looked up in the
+// main chain view, so no block of DAG is read.
+func (con *Conflux) IsOnMainChain(b IBlock) bool {
+	if b == nil {
+		return false
+	}
+	con.lock.RLock()
+	defer con.lock.RUnlock()
+
+	_, ok := con.mainChainHeights[b.GetID()]
+	return ok
 }
 
 // return the tip of main chain
@@ -1,7 +1,13 @@
 package blockdag
 
 import (
+	"bytes"
+	"container/list"
 	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -37,3 +43,1465 @@ func Test_GetOrder(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// Add a block with the given tag on top of the tagged parents of the test DAG.
+func addConfluxBlock(tag string, parents ...string) IBlock {
+	ps := NewIdSet()
+	for _, parent := range parents {
+		ps.Add(tbMap[parent].GetID())
+	}
+	_, ib := bd.AddBlock(buildBlock(ps))
+	if ib != nil {
+		tbMap[tag] = ib
+	}
+	return ib
+}
+
+func Test_ReorgDepth(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	if con.MaxReorgDepth() != 0 || con.LastReorgDepth() != 0 {
+		t.Fatalf("unexpected reorg on the sample DAG: max=%d last=%d", con.MaxReorgDepth(), con.LastReorgDepth())
+	}
+	// The branch of K overtakes E-H.
+	addConfluxBlock("L", "K")
+	if con.LastReorgDepth() != 2 || con.MaxReorgDepth() != 2 {
+		t.Fatalf("expect depth 2, but last=%d max=%d", con.LastReorgDepth(), con.MaxReorgDepth())
+	}
+	// E-H takes back the main chain, rolling back I-K-L.
+	addConfluxBlock("M", "H")
+	addConfluxBlock("N", "M")
+	if con.LastReorgDepth() != 3 || con.MaxReorgDepth() != 3 {
+		t.Fatalf("expect depth 3, but last=%d max=%d", con.LastReorgDepth(), con.MaxReorgDepth())
+	}
+	// Extending the main chain does not roll back anything.
+	addConfluxBlock("O", "N")
+	if con.LastReorgDepth() != 3 || con.MaxReorgDepth() != 3 {
+		t.Fatalf("expect depth 3, but last=%d max=%d", con.LastReorgDepth(), con.MaxReorgDepth())
+	}
+	// I-K-L wins again and E-H-M-N-O are rolled back.
+	addConfluxBlock("P", "L")
+	addConfluxBlock("Q", "P")
+	addConfluxBlock("R", "Q")
+	if con.LastReorgDepth() != 5 || con.MaxReorgDepth() != 5 {
+		t.Fatalf("expect depth 5, but last=%d max=%d", con.LastReorgDepth(), con.MaxReorgDepth())
+	}
+}
+
+func Test_MainChainBlockAt(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	mainChain := changeToIDList(testData.CO_GetMainChain.Output)
+	for height, id := range mainChain {
+		h, ok := con.MainChainBlockAt(height)
+		if !ok || !h.IsEqual(bd.getBlockById(id).GetHash()) {
+			t.Fatalf("main chain block at %d is %v, expect %s", height, h, getBlockTag(id))
+		}
+	}
+	if _, ok := con.MainChainBlockAt(len(mainChain)); ok {
+		t.Fatal("expect no block beyond the pivot tip")
+	}
+	if _, ok := con.MainChainBlockAt(-1); ok {
+		t.Fatal("expect no block at negative height")
+	}
+	// The cache must follow the new main chain.
+	ib := addConfluxBlock("L", "H")
+	h, ok := con.MainChainBlockAt(len(mainChain))
+	if !ok || !h.IsEqual(ib.GetHash()) {
+		t.Fatalf("main chain block at %d is %v, expect L", len(mainChain), h)
+	}
+}
+
+func Test_PendingBlocks(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	hasPending := func(tag string) bool {
+		for _, h := range con.PendingBlocks() {
+			if h.IsEqual(tbMap[tag].GetHash()) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(con.PendingBlocks()) != 1 || !hasPending("K") {
+		t.Fatalf("expect only K is pending, but %d blocks", len(con.PendingBlocks()))
+	}
+	addConfluxBlock("X", "G")
+	if !hasPending("X") || !hasPending("K") || len(con.PendingBlocks()) != 2 {
+		t.Fatal("expect the new tip X is pending")
+	}
+	addConfluxBlock("Y", "H", "X")
+	if hasPending("X") || hasPending("Y") {
+		t.Fatal("expect X is confirmed by Y")
+	}
+	if !hasPending("K") || len(con.PendingBlocks()) != 1 {
+		t.Fatal("expect K is still pending")
+	}
+}
+
+func Test_CompactOrder(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	order := changeToIDList(testData.CO_GetOrder.Output)
+	if err := con.CompactOrder(tbMap["K"].GetOrder()); err == nil {
+		t.Fatal("expect the order after pivot tip can't be compacted")
+	}
+	if err := con.CompactOrder(5); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		if len(bd.order) != int(bd.GetBlockTotal())-5 {
+			t.Fatalf("expect %d orders, but %d", bd.GetBlockTotal()-5, len(bd.order))
+		}
+		for i, id := range order {
+			h := con.GetBlockByOrder(uint(i))
+			if i < 5 {
+				if h != nil {
+					t.Fatalf("expect order %d is compacted", i)
+				}
+				continue
+			}
+			if h == nil || !h.IsEqual(bd.getBlockById(id).GetHash()) {
+				t.Fatalf("block of order %d is %v, expect %s", i, h, getBlockTag(id))
+			}
+		}
+	}
+	check()
+	// The block after the compacted orders has no previous one.
+	if _, err := bd.GetPrevious(order[5]); err == nil {
+		t.Fatal("expect the previous order is compacted")
+	}
+	if pre, err := bd.GetPrevious(order[6]); err != nil || pre != order[5] {
+		t.Fatalf("expect the previous block is %s, but %d: %v", getBlockTag(order[5]), pre, err)
+	}
+	// The compacted orders stay dropped when the order is rebuilt.
+	order = append(order, addConfluxBlock("L", "H", "K").GetID())
+	check()
+	if con.OrderBase() != 5 {
+		t.Fatalf("expect order base 5, but %d", con.OrderBase())
+	}
+}
+
+func Test_IsAncestor(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	testCases := []struct {
+		ancestor   string
+		descendant string
+		result     bool
+	}{
+		// direct
+		{"A", "C", true},
+		{"B", "C", true},
+		// transitive
+		{"Gen", "H", true},
+		{"B", "K", true},
+		{"D", "H", true},
+		// negative
+		{"C", "A", false},
+		{"D", "K", false},
+		{"G", "I", false},
+		{"A", "A", false},
+	}
+	check := func() {
+		for _, tc := range testCases {
+			a := tbMap[tc.ancestor].GetHash()
+			d := tbMap[tc.descendant].GetHash()
+			if bd.IsAncestor(a, d) != tc.result {
+				t.Fatalf("expect IsAncestor(%s,%s) is %v", tc.ancestor, tc.descendant, tc.result)
+			}
+			if bd.IsDescendant(d, a) != tc.result {
+				t.Fatalf("expect IsDescendant(%s,%s) is %v", tc.descendant, tc.ancestor, tc.result)
+			}
+		}
+	}
+	check()
+	bd.EnableAncestorCache(true)
+	check()
+	if len(bd.ancestorCache) == 0 {
+		t.Fatal("expect the results are cached")
+	}
+	// The cached results are still right after the DAG grows.
+	addConfluxBlock("L", "D", "K")
+	check()
+	if !bd.IsAncestor(tbMap["D"].GetHash(), tbMap["L"].GetHash()) {
+		t.Fatal("expect D is the ancestor of L")
+	}
+}
+
+func Test_VirtualTip(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	if !con.HasVirtualTip() {
+		t.Fatal("expect a virtual tip over H and K")
+	}
+	parents := con.VirtualTipParents()
+	if len(parents) != 2 {
+		t.Fatalf("expect 2 parents of virtual tip, but %d", len(parents))
+	}
+	for _, tag := range []string{"H", "K"} {
+		found := false
+		for _, h := range parents {
+			if h.IsEqual(tbMap[tag].GetHash()) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expect %s is merged by the virtual tip", tag)
+		}
+	}
+	// Merge all tips into one.
+	addConfluxBlock("L", "H", "K")
+	if con.HasVirtualTip() || con.VirtualTipParents() != nil {
+		t.Fatal("expect no virtual tip with a single tip")
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	addConfluxBlock("L", "K")
+	stats := con.Stats()
+	if stats.OrderLen != bd.GetBlockTotal() || stats.OrderLen != 13 {
+		t.Fatalf("expect order length 13, but %d", stats.OrderLen)
+	}
+	if stats.MainChainHeight != len(con.GetMainChain())-1 {
+		t.Fatalf("expect main chain height %d, but %d", len(con.GetMainChain())-1, stats.MainChainHeight)
+	}
+	if stats.TipCount != bd.GetTips().Size() || stats.TipCount != 2 {
+		t.Fatalf("expect 2 tips, but %d", stats.TipCount)
+	}
+	if stats.PendingCount != len(con.PendingBlocks()) {
+		t.Fatalf("expect %d pending blocks, but %d", len(con.PendingBlocks()), stats.PendingCount)
+	}
+	if stats.MaxReorgDepth != con.MaxReorgDepth() || stats.LastReorgDepth != con.LastReorgDepth() ||
+		stats.MaxReorgDepth != 2 {
+		t.Fatalf("expect reorg depth 2, but max=%d last=%d", stats.MaxReorgDepth, stats.LastReorgDepth)
+	}
+	avg := averageAnticone()
+	if stats.AverageAnticone != avg || con.AverageAnticoneSize() != avg {
+		t.Fatalf("expect average anticone %f, but %f", avg, stats.AverageAnticone)
+	}
+}
+
+// Compute the average anticone size of all blocks in DAG from scratch.
+func averageAnticone() float64 {
+	con := bd.instance.(*Conflux)
+	total := 0
+	for _, block := range bd.blocks {
+		related := NewIdSet()
+		con.collectRelated(related, block, blockParents)
+		con.collectRelated(related, block, blockChildren)
+		total += len(bd.blocks) - 1 - related.Size()
+	}
+	return float64(total) / float64(len(bd.blocks))
+}
+
+func Test_AverageAnticoneRandom(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		con, _ := buildRandomConflux(300, seed, false)
+		if con == nil {
+			t.Fatalf("seed %d: failed to build DAG", seed)
+		}
+		if avg := averageAnticone(); con.AverageAnticoneSize() != avg {
+			t.Fatalf("seed %d: expect average anticone %f, but %f", seed, avg, con.AverageAnticoneSize())
+		}
+		checkLoadOrdered(t, confluxOrder(con))
+	}
+}
+
+func Test_GetTipsListWithoutPivot(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	// Get the pivot tip out of sync with tips.
+	bd.tips.Remove(tbMap["H"].GetID())
+	tips := con.GetTipsList()
+	if len(tips) != 1 || tips[0].GetID() != tbMap["K"].GetID() {
+		t.Fatalf("expect K is the only tip, but %v", tips)
+	}
+	if con.privotTip.GetID() != tbMap["H"].GetID() {
+		t.Fatalf("expect the getter leaves the pivot tip H, but %s", getBlockTag(con.privotTip.GetID()))
+	}
+	// The next change of DAG selects the pivot tip again, even a block that
+	// is rejected.
+	tempHash++
+	block := &Block{id: bd.blockTotal, hash: hash.MustHexToDecodedHash(fmt.Sprintf("%d", tempHash)),
+		mainParent: 1000, parents: NewIdSet()}
+	block.parents.Add(1000)
+	bd.blocks[block.id] = block
+	bd.blockTotal++
+	if con.AddBlock(block) != nil {
+		t.Fatal("expect the block with unknown main parent is rejected")
+	}
+	if con.privotTip.GetID() != tbMap["K"].GetID() {
+		t.Fatalf("expect K is the new pivot tip, but %s", getBlockTag(con.privotTip.GetID()))
+	}
+	if mainChain := con.GetMainChain(); mainChain[0] != tbMap["K"].GetID() {
+		t.Fatalf("expect the main chain ends at K, but %s", getBlockTag(mainChain[0]))
+	}
+}
+
+func Test_OrderChecksum(t *testing.T) {
+	// The checksum computed from scratch over the current order.
+	fullChecksum := func() []byte {
+		var checksum hash.Hash
+		for i := uint(0); i < bd.GetBlockTotal(); i++ {
+			var data [hash.HashSize * 2]byte
+			if i > 0 {
+				copy(data[:hash.HashSize], checksum[:])
+			}
+			copy(data[hash.HashSize:], bd.getBlockById(bd.order[i]).GetHash()[:])
+			checksum = hash.HashH(data[:])
+		}
+		return checksum[:]
+	}
+	// Build the same DAG twice with the same block hashes.
+	startHash := tempHash
+	con1 := InitBlockDAG(conflux, "CO_Blocks").(*Conflux)
+	checksum1 := con1.OrderChecksum()
+	if !bytes.Equal(checksum1, fullChecksum()) {
+		t.Fatal("checksum is different from the full computation")
+	}
+	tempHash = startHash
+	con2 := InitBlockDAG(conflux, "CO_Blocks").(*Conflux)
+	if !bytes.Equal(checksum1, con2.OrderChecksum()) {
+		t.Fatal("expect the same checksum for the same DAG")
+	}
+	// L reorganizes the order.
+	addConfluxBlock("L", "K")
+	if bytes.Equal(checksum1, con2.OrderChecksum()) {
+		t.Fatal("expect different checksums for divergent DAGs")
+	}
+	if !bytes.Equal(con2.OrderChecksum(), fullChecksum()) {
+		t.Fatal("checksum is not recomputed on reorganization")
+	}
+}
+
+func Test_MaxEpochDepends(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	con.SetMaxEpochDepends(3)
+	// The virtual epoch depends on K, S1 and S2.
+	addConfluxBlock("S1", "Gen")
+	addConfluxBlock("S2", "Gen")
+	order := []*hash.Hash{}
+	for i := uint(0); i < bd.GetBlockTotal(); i++ {
+		order = append(order, con.GetBlockByOrder(i))
+	}
+	checksum := con.OrderChecksum()
+
+	// S3 makes the virtual epoch too wide.
+	ps := NewIdSet()
+	ps.Add(tbMap["Gen"].GetID())
+	total := bd.GetBlockTotal()
+	lastTime := *bd.GetLastTime()
+	tips := bd.GetTips().Size()
+	children := tbMap["Gen"].GetChildren().Size()
+	s3 := buildBlock(ps)
+	s3.timeStamp = lastTime.Unix() + 3600
+	l, ib := bd.AddBlock(s3)
+	if l != nil || ib != nil {
+		t.Fatal("expect S3 can't be ordered")
+	}
+	for i, h := range order {
+		if !con.GetBlockByOrder(uint(i)).IsEqual(h) {
+			t.Fatalf("expect the order %d is kept", i)
+		}
+	}
+	if !bytes.Equal(checksum, con.OrderChecksum()) {
+		t.Fatal("expect S3 is not ordered")
+	}
+	if bd.GetBlockTotal() != total || bd.GetTips().Size() != tips || tbMap["Gen"].GetChildren().Size() != children {
+		t.Fatal("expect S3 is unlinked from DAG")
+	}
+	if !bd.GetLastTime().Equal(lastTime) || con.Stats().OrderLen != total {
+		t.Fatalf("expect the time and the order of S3 are dropped, but %s and %d", bd.GetLastTime(), con.Stats().OrderLen)
+	}
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+
+	con.SetMaxEpochDepends(MaxEpochDepends)
+	if addConfluxBlock("S3", "Gen") == nil || addConfluxBlock("S4", "S3") == nil ||
+		tbMap["S3"].GetOrder() == MaxBlockOrder {
+		t.Fatal("expect S3 is ordered with a larger bound")
+	}
+}
+
+func Test_DanglingParent(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	if ib, ok := bd.GetBlockOK(tbMap["H"].GetHash()); !ok || ib.GetID() != tbMap["H"].GetID() {
+		t.Fatal("expect H is known")
+	}
+	if ib, ok := bd.GetBlockOK(&hash.Hash{0xff, 0xff, 0xff, 0xff}); ok || ib != nil {
+		t.Fatal("expect an unknown block")
+	}
+	// Put blocks with a dangling parent into DAG by hand, the ordering
+	// must reject them instead of panicking.
+	addDangling := func(mainParent uint, parents ...uint) *list.List {
+		tempHash++
+		block := &Block{id: bd.blockTotal, hash: hash.MustHexToDecodedHash(fmt.Sprintf("%d", tempHash)),
+			mainParent: mainParent, parents: NewIdSet()}
+		for _, id := range parents {
+			block.parents.Add(id)
+			if parent := bd.getBlockById(id); parent != nil {
+				parent.AddChild(block)
+			}
+		}
+		bd.blocks[block.id] = block
+		bd.blockTotal++
+		return con.AddBlock(block)
+	}
+	total := bd.GetBlockTotal()
+	weight := con.getWeight(tbMap["H"])
+	if addDangling(1000, 1000) != nil {
+		t.Fatal("expect the block with unknown main parent is rejected")
+	}
+	if addDangling(tbMap["H"].GetID(), tbMap["H"].GetID(), 1000) != nil {
+		t.Fatal("expect the block with unknown parent is rejected")
+	}
+	if bd.GetBlockTotal() != total || tbMap["H"].HasChildren() || con.getWeight(tbMap["H"]) != weight ||
+		con.Stats().OrderLen != total {
+		t.Fatal("expect the rejected blocks are unlinked from DAG")
+	}
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+
+	// DAG rejects an unknown parent before linking the block.
+	ps := NewIdSet()
+	ps.Add(tbMap["H"].GetID())
+	ps.Add(1000)
+	lastTime := *bd.GetLastTime()
+	dangling := buildBlock(ps)
+	dangling.timeStamp = lastTime.Unix() + 3600
+	if l, ib := bd.AddBlock(dangling); l != nil || ib != nil || bd.GetBlockTotal() != total || !bd.GetLastTime().Equal(lastTime) {
+		t.Fatal("expect the block with unknown parent is rejected by DAG")
+	}
+	if tbMap["H"].HasChildren() {
+		t.Fatal("expect the rejected block isn't linked")
+	}
+}
+
+type testOrderObserver struct {
+	events []string
+	order  map[uint]*hash.Hash
+	err    error
+}
+
+func (o *testOrderObserver) OnConnect(order uint, h *hash.Hash) {
+	o.events = append(o.events, fmt.Sprintf("+%d", order))
+	if _, ok := o.order[order]; ok && o.err == nil {
+		o.err = fmt.Errorf("connect to the occupied order %d", order)
+	}
+	o.order[order] = h
+}
+
+func (o *testOrderObserver) OnDisconnect(order uint, h *hash.Hash) {
+	o.events = append(o.events, fmt.Sprintf("-%d", order))
+	if cur, ok := o.order[order]; (!ok || !cur.IsEqual(h)) && o.err == nil {
+		o.err = fmt.Errorf("disconnect the wrong block at order %d", order)
+	}
+	if uint(len(o.order)) != order+1 && o.err == nil {
+		o.err = fmt.Errorf("disconnect order %d is not the last one", order)
+	}
+	delete(o.order, order)
+}
+
+func Test_OrderObserver(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	observer := &testOrderObserver{order: map[uint]*hash.Hash{}}
+	for i := uint(0); i < bd.GetBlockTotal(); i++ {
+		observer.order[i] = con.GetBlockByOrder(i)
+	}
+	con.AddOrderObserver(observer)
+
+	// L extends K, the branch of K becomes the main chain.
+	addConfluxBlock("L", "K")
+	if observer.err != nil {
+		t.Fatal(observer.err)
+	}
+	// The order is rearranged from order 4, right after C.
+	expect := "-11 -10 -9 -8 -7 -6 -5 -4 +4 +5 +6 +7 +8 +9 +10 +11 +12"
+	if strings.Join(observer.events, " ") != expect {
+		t.Fatalf("expect %s, but %s", expect, strings.Join(observer.events, " "))
+	}
+	for i := uint(0); i < bd.GetBlockTotal(); i++ {
+		if !observer.order[i].IsEqual(con.GetBlockByOrder(i)) {
+			t.Fatalf("the observed order %d is different", i)
+		}
+	}
+}
+
+func Test_GetOrderedEpochs(t *testing.T) {
+	con := &Conflux{}
+	if epochs := con.GetOrderedEpochs(); epochs == nil || len(epochs) != 0 {
+		t.Fatal("expect no epoch before adding block")
+	}
+
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con = ibd.(*Conflux)
+	epochs := con.GetOrderedEpochs()
+	mainChain := reverseBlockList(con.GetMainChain())
+	if len(epochs) != len(mainChain) {
+		t.Fatalf("expect %d epochs, but %d", len(mainChain), len(epochs))
+	}
+	multiDepends := false
+	var order uint
+	for i, e := range epochs {
+		if e.GetMain().GetID() != mainChain[i] {
+			t.Fatalf("expect the main block of epoch %d is %d", i, mainChain[i])
+		}
+		if len(e.GetDepends()) > 1 {
+			multiDepends = true
+			es := NewIdSet()
+			for _, dep := range e.GetDepends() {
+				es.Add(dep.GetID())
+			}
+			expect := []IBlock{}
+			for !es.IsEmpty() {
+				fbs := con.getForwardBlocks(es)
+				for _, fb := range fbs {
+					es.Remove(fb.GetID())
+				}
+				expect = append(expect, fbs...)
+			}
+			for j, dep := range e.GetDepends() {
+				if dep.GetID() != expect[j].GetID() {
+					t.Fatalf("expect the depends of epoch %d in forward order", i)
+				}
+			}
+		}
+		for _, block := range e.GetSequence() {
+			if block.GetOrder() != order {
+				t.Fatalf("expect order %d of block %s, but %d", order, block.GetHash(), block.GetOrder())
+			}
+			order++
+		}
+	}
+	if !multiDepends {
+		t.Fatal("expect an epoch with multiple depends")
+	}
+	// The remaining blocks are ordered by the virtual tip.
+	if order+uint(len(con.PendingBlocks())) != bd.GetBlockTotal() {
+		t.Fatalf("expect all blocks are covered by epochs and pending blocks")
+	}
+}
+
+// A generator of random blocks of conflux, every block refers to up to 3 of
+// the tips when one of the 3 latest blocks was added, as if it was mined
+// concurrently.
+type randomConflux struct {
+	con     *Conflux
+	r       *rand.Rand
+	history [][]uint
+}
+
+func newRandomConflux(seed int64, full bool) *randomConflux {
+	bd = BlockDAG{}
+	con := bd.Init(conflux, CalcBlockWeight, -1, onGetBlockId, nil).(*Conflux)
+	con.fullReorder = full
+	return &randomConflux{con: con, r: rand.New(rand.NewSource(seed))}
+}
+
+// Add a random block and return the change of order by it.
+func (rc *randomConflux) addBlock() (string, bool) {
+	r := rc.r
+	parents := NewIdSet()
+	if len(rc.history) > 0 {
+		window := len(rc.history)
+		if window > 3 {
+			window = 3
+		}
+		tips := rc.history[len(rc.history)-1-r.Intn(window)]
+		var minLayer, maxLayer uint
+		for j := r.Intn(3); j >= 0; j-- {
+			tip := bd.getBlockById(tips[r.Intn(len(tips))])
+			layer := tip.GetLayer()
+			if parents.IsEmpty() {
+				minLayer, maxLayer = layer, layer
+			} else if layer+MaxTipLayerGap < maxLayer || layer > minLayer+MaxTipLayerGap {
+				continue
+			}
+			if layer < minLayer {
+				minLayer = layer
+			}
+			if layer > maxLayer {
+				maxLayer = layer
+			}
+			parents.Add(tip.GetID())
+		}
+	}
+	l, ib := bd.AddBlock(buildBlock(parents))
+	if ib == nil {
+		return "", false
+	}
+	rc.history = append(rc.history, bd.tips.SortList(false))
+	change := ""
+	if l != nil {
+		for e := l.Front(); e != nil; e = e.Next() {
+			change += fmt.Sprintf("%d ", e.Value)
+		}
+	}
+	return change, true
+}
+
+// Build a random DAG of conflux by randomConflux, the changes of order by
+// each block are returned.
+func buildRandomConflux(total int, seed int64, full bool) (*Conflux, []string) {
+	rc := newRandomConflux(seed, full)
+	changes := []string{}
+	for i := 0; i < total; i++ {
+		change, ok := rc.addBlock()
+		if !ok {
+			return nil, nil
+		}
+		changes = append(changes, change)
+	}
+	return rc.con, changes
+}
+
+func Test_IncrementalOrder(t *testing.T) {
+	startHash := tempHash
+	full, fullChanges := buildRandomConflux(500, 1, true)
+	if full == nil {
+		t.Fatal("can't build the DAG")
+	}
+	fullOrder := bd.order
+	fullChecksum := full.OrderChecksum()
+	fullBlockOrders := []uint{}
+	for i := uint(0); i < bd.GetBlockTotal(); i++ {
+		fullBlockOrders = append(fullBlockOrders, bd.getBlockById(i).GetOrder())
+	}
+
+	tempHash = startHash
+	con, changes := buildRandomConflux(500, 1, false)
+	if con == nil {
+		t.Fatal("can't build the DAG")
+	}
+	for i := range changes {
+		if changes[i] != fullChanges[i] {
+			t.Fatalf("the change of order by block %d is %s, expect %s", i, changes[i], fullChanges[i])
+		}
+	}
+	if len(bd.order) != len(fullOrder) {
+		t.Fatalf("expect %d orders, but %d", len(fullOrder), len(bd.order))
+	}
+	for i := uint(0); i < bd.GetBlockTotal(); i++ {
+		if bd.order[i] != fullOrder[i] {
+			t.Fatalf("the block of order %d is %d, expect %d", i, bd.order[i], fullOrder[i])
+		}
+		if bd.getBlockById(i).GetOrder() != fullBlockOrders[i] {
+			t.Fatalf("the order of block %d is %d, expect %d", i, bd.getBlockById(i).GetOrder(), fullBlockOrders[i])
+		}
+	}
+	if !bytes.Equal(con.OrderChecksum(), fullChecksum) {
+		t.Fatal("checksum is different from the full computation")
+	}
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func benchmarkConfluxOrder(b *testing.B, full bool) {
+	for n := 0; n < b.N; n++ {
+		if con, _ := buildRandomConflux(5000, 1, full); con == nil {
+			b.Fatal("can't build the DAG")
+		}
+	}
+}
+
+func BenchmarkConfluxFullOrder(b *testing.B) {
+	benchmarkConfluxOrder(b, true)
+}
+
+func BenchmarkConfluxIncrementalOrder(b *testing.B) {
+	benchmarkConfluxOrder(b, false)
+}
+
+// The cost of adding a block to DAG of different sizes, it doesn't grow with
+// the size since only the part of main chain after the fork is walked. DAG is
+// built again once it has doubled.
+func BenchmarkConfluxAddBlock(b *testing.B) {
+	for _, size := range []int{1000, 4000, 16000} {
+		b.Run(fmt.Sprintf("blocks-%d", size), func(b *testing.B) {
+			var rc *randomConflux
+			for n := 0; n < b.N; n++ {
+				if n%size == 0 {
+					b.StopTimer()
+					rc = newRandomConflux(1, false)
+					for i := 0; i < size; i++ {
+						rc.addBlock()
+					}
+					b.StartTimer()
+				}
+				if _, ok := rc.addBlock(); !ok {
+					b.Fatal("can't add the block")
+				}
+			}
+		})
+	}
+}
+
+func Test_ValidateOrder(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+	expectOrderError := func(err error, order uint) {
+		oerr, ok := err.(*OrderError)
+		if !ok || oerr.Order != order {
+			t.Fatalf("expect the order error at %d, but %v", order, err)
+		}
+	}
+
+	// The block disagrees with its order.
+	c := tbMap["C"]
+	c.SetOrder(7)
+	expectOrderError(con.ValidateOrder(), 3)
+	c.SetOrder(3)
+
+	// The block is at two orders and one block is lost.
+	d := bd.order[4]
+	bd.order[4] = c.GetID()
+	expectOrderError(con.ValidateOrder(), 4)
+	delete(bd.order, 4)
+	expectOrderError(con.ValidateOrder(), 4)
+	bd.order[4] = d
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The epoch can't be ordered on an inconsistent order, instead of
+	// panicking.
+	e := tbMap["E"]
+	epoch := &Epoch{main: tbMap["A"]}
+	_, err := con.updateOrder(e, epoch, NewHashSet())
+	if _, ok := err.(*OrderError); !ok {
+		t.Fatalf("expect the order error of epoch, but %v", err)
+	}
+}
+
+func Test_GetBlockByOrderOK(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	check := func(order []uint) {
+		for i, id := range order {
+			h, ok := con.GetBlockByOrderOK(uint(i))
+			if !ok || !h.IsEqual(bd.getBlockById(id).GetHash()) {
+				t.Fatalf("block of order %d is %v, expect %s", i, h, getBlockTag(id))
+			}
+			o, ok := con.GetOrderOfBlock(h)
+			if !ok || o != uint(i) {
+				t.Fatalf("expect order %d of %s, but %d", i, getBlockTag(id), o)
+			}
+		}
+		if h, ok := con.GetBlockByOrderOK(uint(len(order))); ok {
+			t.Fatalf("expect no block after the last order, but %s", h)
+		}
+	}
+	order := changeToIDList(testData.CO_GetOrder.Output)
+	check(order)
+
+	// The virtual block merges H and K, but it has no order.
+	if !con.HasVirtualTip() {
+		t.Fatal("expect a virtual tip")
+	}
+	if _, ok := con.GetOrderOfBlock(&hash.Hash{}); ok {
+		t.Fatal("expect no order of the virtual block")
+	}
+	if _, ok := con.GetOrderOfBlock(nil); ok {
+		t.Fatal("expect no order of nil hash")
+	}
+
+	// The lookups follow the order that is rebuilt by AddBlock.
+	l := addConfluxBlock("L", "H", "K")
+	order = make([]uint, bd.GetBlockTotal())
+	for id := uint(0); id < bd.GetBlockTotal(); id++ {
+		order[bd.getBlockById(id).GetOrder()] = id
+	}
+	if order[len(order)-1] != l.GetID() {
+		t.Fatal("expect L is the last ordered block")
+	}
+	check(order)
+
+	if err := con.CompactOrder(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := con.GetBlockByOrderOK(2); ok {
+		t.Fatal("expect order 2 is compacted")
+	}
+	if o, ok := con.GetOrderOfBlock(bd.getBlockById(order[2]).GetHash()); !ok || o != 2 {
+		t.Fatalf("expect order 2 of compacted block, but %d", o)
+	}
+}
+
+func Test_Anticone(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	anticone, err := con.Anticone(tbMap["D"].GetHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"B", "C", "F", "G", "J", "I", "K"}
+	if anticone.Size() != len(expect) {
+		t.Fatalf("expect %d blocks in anticone of D, but %d", len(expect), anticone.Size())
+	}
+	for _, tag := range expect {
+		if !anticone.Has(tbMap[tag].GetHash()) {
+			t.Fatalf("expect %s in anticone of D", tag)
+		}
+	}
+
+	// The virtual block merging H and K is excluded.
+	anticone, err = con.Anticone(tbMap["K"].GetHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anticone.Has(&hash.Hash{}) || anticone.Has(tbMap["K"].GetHash()) {
+		t.Fatal("expect neither K nor the virtual block in anticone of K")
+	}
+	for tag, block := range tbMap {
+		anticone, err := con.Anticone(block.GetHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := bd.getAnticone(block, nil); anticone.Size() != expect.Size() {
+			t.Fatalf("expect %d blocks in anticone of %s, but %d", expect.Size(), tag, anticone.Size())
+		}
+	}
+
+	if _, err := con.Anticone(&hash.Hash{}); err == nil {
+		t.Fatal("expect an error for unknown block")
+	}
+}
+
+func Test_ReorgCallback(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	var events [][2][]*hash.Hash
+	tags := func(hs []*hash.Hash) string {
+		result := []string{}
+		for _, h := range hs {
+			result = append(result, getBlockTag(bd.getBlock(h).GetID()))
+		}
+		return strings.Join(result, ",")
+	}
+	con.SetReorgCallback(func(removed, added []*hash.Hash) {
+		events = append(events, [2][]*hash.Hash{removed, added})
+	})
+	// The tag of new block is only known after it's added.
+	check := func(expect ...string) {
+		result := []string{}
+		for _, e := range events {
+			result = append(result, fmt.Sprintf("-%s +%s", tags(e[0]), tags(e[1])))
+		}
+		if strings.Join(result, " ") != strings.Join(expect, " ") {
+			t.Fatalf("expect %v, but %v", expect, result)
+		}
+		events = nil
+	}
+
+	// The branch of K overtakes E-H.
+	addConfluxBlock("L", "K")
+	check("-H,E +I,K,L")
+	// Extending the main chain is not a reorganization.
+	addConfluxBlock("M", "L")
+	check()
+	// A block off the main chain doesn't change it.
+	addConfluxBlock("N", "H")
+	check()
+	// E-H takes back the main chain.
+	addConfluxBlock("O", "N")
+	check("-M,L,K,I +E,H,N,O")
+	addConfluxBlock("P", "O")
+	check()
+}
+
+func Test_OrderTrace(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	trace := &bytes.Buffer{}
+	con.SetOrderTrace(trace)
+
+	// L and M fork from H with equal weight, the smaller hash wins.
+	l := addConfluxBlock("L", "H")
+	m := addConfluxBlock("M", "H")
+	if l.GetHash().String() > m.GetHash().String() {
+		l, m = m, l
+	}
+	expect := fmt.Sprintf("pivot %s: %s(weight 0) %s(weight 0) -> %s by smallest hash of equal weight\n",
+		tbMap["H"].GetHash(), l.GetHash(), m.GetHash(), l.GetHash())
+	if !strings.Contains(trace.String(), expect) {
+		t.Fatalf("expect the trace has %q, but\n%s", expect, trace.String())
+	}
+	if !con.IsOnMainChain(l) {
+		t.Fatal("expect the block of smaller hash on the main chain")
+	}
+
+	// N makes the branch of M heavier.
+	addConfluxBlock("N", "M")
+	expect = fmt.Sprintf("pivot %s: %s(weight 0) %s(weight 1) -> %s by heaviest\n",
+		tbMap["H"].GetHash(), l.GetHash(), m.GetHash(), m.GetHash())
+	if !strings.Contains(trace.String(), expect) {
+		t.Fatalf("expect the trace has %q, but\n%s", expect, trace.String())
+	}
+
+	con.SetOrderTrace(nil)
+	trace.Reset()
+	addConfluxBlock("O", "N")
+	if trace.Len() != 0 {
+		t.Fatal("expect no trace after it's unset")
+	}
+}
+
+// Load the blocks of bd into a new DAG with the given order, and check that
+// the result is the same as adding them one by one.
+func checkLoadOrdered(t *testing.T, order []*hash.Hash) *Conflux {
+	blocks := []IBlockData{}
+	for id := uint(0); id < bd.GetBlockTotal(); id++ {
+		block := bd.getBlockById(id)
+		parents := NewIdSet()
+		if block.HasParents() {
+			parents.AddList(block.GetParents().List())
+		}
+		blocks = append(blocks, &TestBlock{hash: *block.GetHash(), parents: parents})
+	}
+	expect := bd.instance.(*Conflux)
+
+	loaded := &BlockDAG{}
+	con := loaded.Init(conflux, CalcBlockWeight, -1, onGetBlockId, nil).(*Conflux)
+	if err := con.LoadOrdered(blocks, order); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(con.OrderChecksum(), expect.OrderChecksum()) {
+		t.Fatal("expect the same order as adding blocks one by one")
+	}
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+	if !processResult(con.GetMainChain(), expect.GetMainChain()) {
+		t.Fatal("expect the same main chain as adding blocks one by one")
+	}
+	if con.AverageAnticoneSize() != expect.AverageAnticoneSize() {
+		t.Fatalf("expect average anticone %f, but %f", expect.AverageAnticoneSize(), con.AverageAnticoneSize())
+	}
+	for id := uint(0); id < bd.GetBlockTotal(); id++ {
+		if w, want := con.weights.get(id), expect.weights.get(id); w != want {
+			t.Fatalf("expect weight %d of block (%d), but %d", want, id, w)
+		}
+	}
+	if con.HasVirtualTip() != expect.HasVirtualTip() {
+		t.Fatal("expect the same virtual tip as adding blocks one by one")
+	}
+	if got, want := fmt.Sprint(epochIds(con.epochs)), fmt.Sprint(epochIds(expect.epochs)); got != want {
+		t.Fatalf("the epochs are %s, expect %s", got, want)
+	}
+	if err := con.LoadOrdered(blocks, order); err == nil {
+		t.Fatal("expect blocks can't be loaded twice")
+	}
+	return con
+}
+
+// Return the ids of the blocks of every epoch in order.
+func epochIds(epochs []*Epoch) [][]uint {
+	result := [][]uint{}
+	for _, epoch := range epochs {
+		ids := []uint{}
+		for _, block := range epoch.GetSequence() {
+			ids = append(ids, block.GetID())
+		}
+		result = append(result, ids)
+	}
+	return result
+}
+
+// Return the blocks of DAG in the order of Conflux.
+func confluxOrder(con *Conflux) []*hash.Hash {
+	order := []*hash.Hash{}
+	for i := uint(0); i < con.bd.GetBlockTotal(); i++ {
+		order = append(order, con.GetBlockByOrder(i))
+	}
+	return order
+}
+
+func Test_LoadOrdered(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	order := confluxOrder(con)
+	loaded := checkLoadOrdered(t, order)
+	if err := loaded.setOrder(loadedBlocks(loaded), order); err != nil {
+		t.Fatalf("expect the order is trusted: %s", err)
+	}
+
+	// The wrong order is replaced by the computed one.
+	wrong := append([]*hash.Hash{}, order...)
+	wrong[3], wrong[4] = wrong[4], wrong[3]
+	loaded = checkLoadOrdered(t, wrong)
+	if err := loaded.setOrder(loadedBlocks(loaded), wrong); err == nil {
+		t.Fatal("expect the wrong order is found")
+	}
+	checkLoadOrdered(t, nil)
+
+	// DAG is left empty if a block can't be loaded.
+	blocks := []IBlockData{}
+	for id := uint(0); id < bd.GetBlockTotal(); id++ {
+		parents := NewIdSet()
+		if block := bd.getBlockById(id); block.HasParents() {
+			parents.AddList(block.GetParents().List())
+		}
+		if id == 5 {
+			parents.Add(1000)
+		}
+		blocks = append(blocks, &TestBlock{hash: *bd.getBlockById(id).GetHash(), parents: parents})
+	}
+	empty := &BlockDAG{}
+	emptyCon := empty.Init(conflux, CalcBlockWeight, -1, onGetBlockId, nil).(*Conflux)
+	if err := emptyCon.LoadOrdered(blocks, order); err == nil {
+		t.Fatal("expect the block with unknown parent can't be loaded")
+	}
+	if empty.GetBlockTotal() != 0 || !empty.tips.IsEmpty() || len(empty.blocks) != 0 ||
+		emptyCon.AverageAnticoneSize() != 0 || len(emptyCon.GetOrder()) != 0 {
+		t.Fatal("expect DAG is empty after the load failed")
+	}
+	blocks[5].(*TestBlock).parents.Remove(1000)
+	if err := emptyCon.LoadOrdered(blocks, order); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(emptyCon.OrderChecksum(), con.OrderChecksum()) {
+		t.Fatal("expect the blocks are loaded again")
+	}
+
+	con, _ = buildRandomConflux(300, 7, false)
+	if con == nil {
+		t.FailNow()
+	}
+	order = confluxOrder(con)
+	loaded = checkLoadOrdered(t, order)
+	if err := loaded.setOrder(loadedBlocks(loaded), order); err != nil {
+		t.Fatalf("expect the order is trusted: %s", err)
+	}
+}
+
+// Return the blocks of DAG by id.
+func loadedBlocks(con *Conflux) []IBlock {
+	result := []IBlock{}
+	for id := uint(0); id < con.bd.GetBlockTotal(); id++ {
+		result = append(result, con.bd.getBlockById(id))
+	}
+	return result
+}
+
+func Test_VerifyWeights(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+	addConfluxBlock("L", "K")
+	addConfluxBlock("M", "H")
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := tbMap["C"]
+	weight := con.getWeight(c)
+	con.weights.set(c.GetID(), weight+1)
+	err := con.VerifyWeights()
+	if err == nil || !strings.Contains(err.Error(), c.GetHash().String()) {
+		t.Fatalf("expect the weight of C is wrong, but %v", err)
+	}
+	con.weights.set(c.GetID(), weight)
+
+	con, _ = buildRandomConflux(200, 3, false)
+	if con == nil {
+		t.FailNow()
+	}
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_SelectTips(t *testing.T) {
+	con := &Conflux{bd: &BlockDAG{}}
+	if tips := con.SelectTips(3); len(tips) != 0 {
+		t.Fatal("expect no tip of empty DAG")
+	}
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con = ibd.(*Conflux)
+	for _, b := range []struct{ tag, parent string }{{"L", "C"}, {"M", "J"}, {"N", "M"}} {
+		if addConfluxBlock(b.tag, b.parent) == nil {
+			t.Fatalf("can't add %s", b.tag)
+		}
+	}
+	tags := func(hs []*hash.Hash) string {
+		result := []string{}
+		for _, h := range hs {
+			result = append(result, getBlockTag(bd.getBlock(h).GetID()))
+		}
+		return strings.Join(result, ",")
+	}
+	if pivot := getBlockTag(con.privotTip.GetID()); pivot != "H" {
+		t.Fatalf("expect pivot tip H, but %s", pivot)
+	}
+	// The others have no weight, the higher layer wins.
+	expect := "H,K,N,L"
+	if result := tags(con.SelectTips(10)); result != expect {
+		t.Fatalf("expect tips %s, but %s", expect, result)
+	}
+	if result := tags(con.SelectTips(2)); result != "H,K" {
+		t.Fatalf("expect tips H,K, but %s", result)
+	}
+	if tips := con.SelectTips(0); len(tips) != 0 {
+		t.Fatal("expect no tip for max 0")
+	}
+
+	addConfluxBlock("O", "H", "K", "N", "L")
+	if result := tags(con.SelectTips(3)); result != "O" {
+		t.Fatalf("expect the single tip O, but %s", result)
+	}
+}
+
+func Test_GetAncestorsAndDescendants(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	genesis := tbMap["Gen"].GetHash()
+	for tag, block := range tbMap {
+		ancestors, err := con.GetAncestors(block.GetHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ancestors.Has(block.GetHash()) {
+			t.Fatalf("expect %s isn't its own ancestor", tag)
+		}
+		if tag != "Gen" && !ancestors.Has(genesis) {
+			t.Fatalf("expect genesis is an ancestor of %s", tag)
+		}
+		descendants, err := con.GetDescendants(block.GetHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if descendants.Has(block.GetHash()) || descendants.Has(&hash.Hash{}) {
+			t.Fatalf("expect neither %s nor the virtual block in its descendants", tag)
+		}
+		for otherTag, other := range tbMap {
+			if ancestors.Has(other.GetHash()) != bd.IsAncestor(other.GetHash(), block.GetHash()) {
+				t.Fatalf("expect %s is an ancestor of %s: %v", otherTag, tag, !ancestors.Has(other.GetHash()))
+			}
+			if descendants.Has(other.GetHash()) != bd.IsAncestor(block.GetHash(), other.GetHash()) {
+				t.Fatalf("expect %s is a descendant of %s: %v", otherTag, tag, !descendants.Has(other.GetHash()))
+			}
+		}
+	}
+
+	descendants, err := con.GetDescendants(tbMap["I"].GetHash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descendants.Size() != 2 || !descendants.Has(tbMap["H"].GetHash()) || !descendants.Has(tbMap["K"].GetHash()) {
+		t.Fatal("expect H and K are the descendants of I")
+	}
+	if _, err := con.GetAncestors(&hash.Hash{}); err == nil {
+		t.Fatal("expect an error for unknown block")
+	}
+}
+
+func Test_MissingGenesis(t *testing.T) {
+	con := &Conflux{}
+	if con.Init(nil) {
+		t.Fatal("expect Init fails without DAG")
+	}
+	dag := &BlockDAG{}
+	if !con.Init(dag) {
+		t.Fatal("expect Init succeeds with an empty DAG")
+	}
+	// The block is added without genesis.
+	block := &Block{id: 1, hash: hash.Hash{1}, mainParent: MaxId}
+	dag.blocks = map[uint]IBlock{1: block}
+	dag.blockTotal = 2
+	if con.AddBlock(block) != nil {
+		t.Fatal("expect the block isn't ordered without genesis")
+	}
+	if con.Init(dag) {
+		t.Fatal("expect Init fails without genesis")
+	}
+
+	// Two blocks without parents
+	dag.blocks[0] = &Block{id: 0, hash: hash.Hash{2}, mainParent: MaxId}
+	dag.genesis = hash.Hash{2}
+	if con.Init(dag) {
+		t.Fatal("expect Init fails with another block without parents")
+	}
+
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	if !(&Conflux{}).Init(&bd) {
+		t.Fatal("expect Init succeeds with the sample DAG")
+	}
+}
+
+func confluxOrderTags() []string {
+	result := []string{}
+	for o := uint(0); o < uint(len(bd.blocks)); o++ {
+		result = append(result, getBlockTag(bd.order[o]))
+	}
+	return result
+}
+
+func Test_RemoveBlock(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	order := fmt.Sprint(confluxOrderTags())
+	checksum := con.OrderChecksum()
+	reorgs := 0
+	con.SetReorgCallback(func(removed, added []*hash.Hash) {
+		reorgs++
+	})
+
+	// The branch of K overtakes E-H, and removing L brings it back.
+	l := addConfluxBlock("L", "K")
+	if err := con.RemoveBlock(l.GetHash()); err != nil {
+		t.Fatal(err)
+	}
+	delete(tbMap, "L")
+	if reorgs != 2 {
+		t.Fatalf("expect 2 reorganizations, but %d", reorgs)
+	}
+	if got := fmt.Sprint(confluxOrderTags()); got != order {
+		t.Fatalf("the order is %s, expect %s", got, order)
+	}
+	if !bytes.Equal(con.OrderChecksum(), checksum) {
+		t.Fatalf("the order checksum isn't restored")
+	}
+	if len(bd.blocks) != 12 || bd.getBlockById(l.GetID()) != nil || bd.tips.Size() != 2 || !bd.tips.Has(tbMap["K"].GetID()) {
+		t.Fatalf("unexpected DAG after removal: blocks=%d tips=%v", len(bd.blocks), bd.tips.List())
+	}
+	if avg := averageAnticone(); con.AverageAnticoneSize() != avg {
+		t.Fatalf("expect average anticone %f, but %f", avg, con.AverageAnticoneSize())
+	}
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The blocks added after the removed one keep their ids.
+	l = addConfluxBlock("L", "K")
+	m := addConfluxBlock("M", "H")
+	mid := m.GetID()
+	if err := con.RemoveBlock(l.GetHash()); err != nil {
+		t.Fatal(err)
+	}
+	delete(tbMap, "L")
+	if m.GetID() != mid || !bd.tips.Has(mid) || bd.getBlockById(mid) != m || bd.getBlockById(l.GetID()) != nil {
+		t.Fatalf("the id of M is %d, expect %d", m.GetID(), mid)
+	}
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+	if err := con.VerifyWeights(); err != nil {
+		t.Fatal(err)
+	}
+	if n := addConfluxBlock("N", "M", "K"); n == nil || n.GetID() != mid+1 {
+		t.Fatalf("can't add block after removal")
+	}
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := con.RemoveBlock(bd.getGenesis().GetHash()); err == nil {
+		t.Fatalf("genesis is removed")
+	}
+	if err := con.RemoveBlock(tbMap["M"].GetHash()); err == nil {
+		t.Fatalf("block with children is removed")
+	}
+	if err := con.RemoveBlock(&hash.Hash{}); err == nil {
+		t.Fatalf("unknown block is removed")
+	}
+}
+
+func Test_ConcurrentOrder(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	con := ibd.(*Conflux)
+	done := make(chan struct{})
+	errs := make(chan error, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				order := con.GetOrder()
+				seen := NewHashSet()
+				for _, h := range order {
+					if seen.Has(h) {
+						errs <- fmt.Errorf("block %s is at multiple orders", h)
+						return
+					}
+					seen.Add(h)
+				}
+				mainChain := con.GetMainChain()
+				if len(mainChain) == 0 || mainChain[len(mainChain)-1] != 0 {
+					errs <- fmt.Errorf("the main chain doesn't end at genesis")
+					return
+				}
+				con.GetBlockByOrder(uint(len(order) - 1))
+				if _, ok := con.MainChainBlockAt(con.MainChainHeight()); !ok {
+					errs <- fmt.Errorf("no block at the height of main chain")
+					return
+				}
+				con.AverageAnticoneSize()
+				con.OrderChecksum()
+				con.HasVirtualTip()
+				con.LastReorgDepth()
+				// The readers walking the blocks of DAG
+				if stats := con.Stats(); stats.MainChainHeight < 0 {
+					errs <- fmt.Errorf("no main chain in stats")
+					return
+				}
+				con.PendingBlocks()
+				con.VirtualTipParents()
+				genesis := bd.GetGenesisHash()
+				if _, err := con.GetDescendants(genesis); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := con.Anticone(genesis); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := con.GetAncestors(genesis); err != nil {
+					errs <- err
+					return
+				}
+				if _, ok := con.GetOrderOfBlock(genesis); !ok {
+					errs <- fmt.Errorf("no order of genesis")
+					return
+				}
+				if err := con.VerifyWeights(); err != nil {
+					errs <- err
+					return
+				}
+				if err := con.ValidateOrder(); err != nil {
+					errs <- err
+					return
+				}
+				con.SelectTips(3)
+			}
+		}()
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		tips := bd.tips.SortList(false)
+		parents := NewIdSet()
+		for j := r.Intn(2); j >= 0; j-- {
+			parents.Add(tips[r.Intn(len(tips))])
+		}
+		if _, ib := bd.AddBlock(buildBlock(parents)); ib == nil {
+			t.Fatalf("can't add block %d", i)
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	order := con.GetOrder()
+	if uint(len(order)) != bd.blockTotal {
+		t.Fatalf("expect %d blocks in order, but %d", bd.blockTotal, len(order))
+	}
+	if err := con.ValidateOrder(); err != nil {
+		t.Fatal(err)
+	}
+	// The order kept incrementally is the same as the one from scratch.
+	con.lock.Lock()
+	err := con.reorderAll()
+	con.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range order {
+		if !bd.getBlockById(bd.order[uint(i)]).GetHash().IsEqual(h) {
+			t.Fatalf("the order %d is different from the one from scratch", i)
+		}
+	}
+}
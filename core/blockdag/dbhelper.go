@@ -53,3 +53,35 @@ func DBPutDAGInfo(dbTx database.Tx, bd *BlockDAG) error {
 	}
 	return dbTx.Metadata().Put(dbnamespace.DagInfoBucketName, buff.Bytes())
 }
+
+// DBPutDAGTips stores the block ids of the current dag tips.
+func DBPutDAGTips(dbTx database.Tx, tips []uint) error {
+	serialized := make([]byte, 4+4*len(tips))
+	dbnamespace.ByteOrder.PutUint32(serialized[:4], uint32(len(tips)))
+	for i, id := range tips {
+		offset := 4 + 4*i
+		dbnamespace.ByteOrder.PutUint32(serialized[offset:offset+4], uint32(id))
+	}
+	return dbTx.Metadata().Put(dbnamespace.DagTipsBucketName, serialized)
+}
+
+// DBGetDAGTips fetches the block ids of the dag tips that was stored.
+func DBGetDAGTips(dbTx database.Tx) ([]uint, error) {
+	serialized := dbTx.Metadata().Get(dbnamespace.DagTipsBucketName)
+	if serialized == nil {
+		return nil, fmt.Errorf("get dag tips error")
+	}
+	if len(serialized) < 4 {
+		return nil, fmt.Errorf("dag tips data is corrupt")
+	}
+	num := int(dbnamespace.ByteOrder.Uint32(serialized[:4]))
+	if len(serialized) != 4+4*num {
+		return nil, fmt.Errorf("dag tips data is corrupt")
+	}
+	tips := make([]uint, num)
+	for i := 0; i < num; i++ {
+		offset := 4 + 4*i
+		tips[i] = uint(dbnamespace.ByteOrder.Uint32(serialized[offset : offset+4]))
+	}
+	return tips, nil
+}
@@ -0,0 +1,80 @@
+package blockdag
+
+import (
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a temporary database, the returned function will remove it.
+func createTestDB(t *testing.T) (database.DB, func()) {
+	dir, err := ioutil.TempDir("", "blockdag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dir, "db"), protocol.MainNet)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_SaveLoadTips(t *testing.T) {
+	ibd := InitBlockDAG(conflux, "CO_Blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	db, teardown := createTestDB(t)
+	defer teardown()
+
+	expect := NewIdSet()
+	for _, ib := range bd.GetTipsList() {
+		expect.Add(ib.GetID())
+	}
+	err := db.Update(func(dbTx database.Tx) error {
+		return bd.SaveTips(dbTx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd.tips = NewIdSet()
+
+	err = db.View(func(dbTx database.Tx) error {
+		return bd.LoadTips(dbTx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tips := NewIdSet()
+	for _, ib := range bd.GetTipsList() {
+		tips.Add(ib.GetID())
+	}
+	if !tips.IsEqual(expect) {
+		t.Fatalf("restored tips %v, expect %v", tips.List(), expect.List())
+	}
+
+	// A block that has children can't be restored as tip.
+	err = db.Update(func(dbTx database.Tx) error {
+		return DBPutDAGTips(dbTx, []uint{tbMap["E"].GetID()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		return bd.LoadTips(dbTx)
+	})
+	if err == nil {
+		t.Fatal("expect error for a tip that has children")
+	}
+	if !bd.tips.IsEqual(expect) {
+		t.Fatal("tips were changed by a failed load")
+	}
+}
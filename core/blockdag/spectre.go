@@ -4,11 +4,9 @@ import (
 	"container/list"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
-	"github.com/Qitmeer/qitmeer/common/util"
 	"github.com/Qitmeer/qitmeer/database"
-	"github.com/golang-collections/collections/stack"
 	"io"
-	"strconv"
+	"sort"
 )
 
 type Spectre struct {
@@ -18,15 +16,18 @@ type Spectre struct {
 	// voting votes, true value means voting the first candidate, otherwise voting the second
 	votes map[hash.Hash]bool
 
-	// the nodes don't exist in future sets of 1 and 2, but they are referenced by the future sets
-	//  e.g. node 7 in ByteBall 2
-	dangling *HashSet
+	// the blocks in the future sets of both candidates, they vote recursively by their past sets
+	common idBits
 
 	// the candidates to compete with each other
 	candidate1, candidate2 IBlock
 
 	// The votes of block
 	sblocks map[hash.Hash]*SpectreBlock
+
+	// The past and future sets of blocks by id
+	pasts   map[uint]idBits
+	futures map[uint]idBits
 }
 
 func (sp *Spectre) GetName() string {
@@ -37,7 +38,8 @@ func (sp *Spectre) Init(bd *BlockDAG) bool {
 	sp.bd = bd
 
 	sp.votes = make(map[hash.Hash]bool)
-	sp.dangling = NewHashSet()
+	sp.pasts = map[uint]idBits{}
+	sp.futures = map[uint]idBits{}
 
 	return true
 }
@@ -48,23 +50,187 @@ func (sp *Spectre) AddBlock(b IBlock) *list.List {
 	}
 	block := SpectreBlock{hash: *b.GetHash(), Votes1: -1, Votes2: -1}
 	sp.sblocks[block.hash] = &block
+	sp.linkSets(b)
 
 	var result *list.List = list.New()
-	result.PushBack(block.GetHash())
+	for _, id := range sp.updateOrder(b) {
+		result.PushBack(sp.bd.getBlockById(id).GetHash())
+	}
 	return result
 }
 
+// Order the blocks again by the pairwise votes, and return the blocks whose
+// order has changed, from the first changed order forward. If the new block
+// is in the future of all other blocks, it votes for the pairs of the old DAG
+// as its virtual block did, the other blocks can only follow it, so the old
+// order is kept and the new block is ordered last. Otherwise any vote can
+// change and the whole DAG is ordered again.
+func (sp *Spectre) updateOrder(b IBlock) []uint {
+	start := uint(0)
+	if sp.pasts[b.GetID()].size() == int(sp.bd.blockTotal)-1 {
+		start = sp.bd.blockTotal - 1
+	}
+	return sp.orderFrom(start, b)
+}
+
+// Add the new block to the future sets of its past.
+func (sp *Spectre) linkSets(b IBlock) {
+	past := idBits{}
+	if b.HasParents() {
+		for _, pid := range b.GetParents().List() {
+			past.add(pid)
+			past.addSet(sp.pasts[pid])
+		}
+	}
+	sp.pasts[b.GetID()] = past
+	sp.futures[b.GetID()] = idBits{}
+	for _, id := range past.list() {
+		future := sp.futures[id]
+		future.add(b.GetID())
+		sp.futures[id] = future
+	}
+}
+
+// Order the new block and the blocks from start forward. Each step orders
+// the block that wins the most votes against the other blocks whose parents
+// are all ordered, these blocks are in the anticone of each other, so the
+// order always respects the topology of DAG.
+func (sp *Spectre) orderFrom(start uint, b IBlock) []uint {
+	if sp.bd.order == nil {
+		sp.bd.order = map[uint]uint{}
+	}
+	blocks := NewIdSet()
+	for id := uint(0); id < sp.bd.blockTotal; id++ {
+		if id == b.GetID() || sp.bd.getBlockById(id).GetOrder() >= start {
+			blocks.Add(id)
+		}
+	}
+
+	ready := NewIdSet()
+	pending := map[uint]int{}
+	for _, id := range blocks.List() {
+		block := sp.bd.getBlockById(id)
+		if !block.HasParents() {
+			ready.Add(id)
+			continue
+		}
+		for _, pid := range block.GetParents().List() {
+			if blocks.Has(pid) {
+				pending[id]++
+			}
+		}
+		if pending[id] == 0 {
+			ready.Add(id)
+			delete(pending, id)
+		}
+	}
+
+	// DAG doesn't change while ordering, so each pair is voted once.
+	precedes := map[[2]uint]bool{}
+	changed := []uint{}
+	for o := start; o < sp.bd.blockTotal; o++ {
+		var next uint
+		maxWins := -1
+		for _, id := range ready.SortList(false) {
+			wins := 0
+			for _, other := range ready.List() {
+				if other == id {
+					continue
+				}
+				first, ok := precedes[[2]uint{id, other}]
+				if !ok {
+					first = sp.precedes(id, other)
+					precedes[[2]uint{id, other}] = first
+					precedes[[2]uint{other, id}] = !first
+				}
+				if first {
+					wins++
+				}
+			}
+			if wins > maxWins {
+				next, maxWins = id, wins
+			}
+		}
+		ready.Remove(next)
+
+		old, ok := sp.bd.order[o]
+		if len(changed) > 0 || !ok || old != next {
+			changed = append(changed, next)
+		}
+		sp.bd.order[o] = next
+		block := sp.bd.getBlockById(next)
+		block.SetOrder(o)
+
+		if !block.HasChildren() {
+			continue
+		}
+		for _, child := range block.GetChildren().List() {
+			pending[child]--
+			if pending[child] == 0 {
+				ready.Add(child)
+				delete(pending, child)
+			}
+		}
+	}
+	return changed
+}
+
+// Whether b1 precedes b2 by the recursive vote of the whole DAG.
+func (sp *Spectre) precedes(b1 uint, b2 uint) bool {
+	first, err := sp.Vote(sp.bd.getBlockById(b1), sp.bd.getBlockById(b2))
+	if err != nil {
+		log.Error(err.Error())
+	}
+	return first
+}
+
 // Build self block
 func (sp *Spectre) CreateBlock(b *Block) IBlock {
 	return b
 }
 
+// Return the tips from the last ordered one backward.
 func (sp *Spectre) GetTipsList() []IBlock {
-	return nil
+	if sp.bd.tips == nil || sp.bd.tips.IsEmpty() {
+		return nil
+	}
+	result := []IBlock{}
+	for _, id := range sp.bd.tips.List() {
+		result = append(result, sp.bd.getBlockById(id))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetOrder() > result[j].GetOrder()
+	})
+	return result
 }
 
 func (sp *Spectre) GetBlockByOrder(order uint) *hash.Hash {
-	return nil
+	id, ok := sp.bd.order[order]
+	if !ok || order >= sp.bd.blockTotal {
+		return nil
+	}
+	return sp.bd.getBlockById(id).GetHash()
+}
+
+// Spectre has no main chain of its own, the chain is from the last ordered
+// tip backward through the parent ordered last.
+func (sp *Spectre) GetMainChain() []uint {
+	result := []uint{}
+	for b := sp.GetMainChainTip(); b != nil; {
+		result = append(result, b.GetID())
+		var next IBlock
+		if b.GetParents() == nil {
+			break
+		}
+		for _, id := range b.GetParents().List() {
+			parent := sp.bd.getBlockById(id)
+			if next == nil || parent.GetOrder() > next.GetOrder() {
+				next = parent
+			}
+		}
+		b = next
+	}
+	return result
 }
 
 func (sp *Spectre) voteFirst(voter hash.Hash) {
@@ -108,427 +274,90 @@ func (sp *Spectre) InitVote(b1 IBlock, b2 IBlock) (bool, error) {
 	} else if sp.IsInPastOf(b2, b1) {
 		return false, fmt.Errorf("block %v is in past of block %v", b2.GetHash(), b1.GetHash())
 	}
+	sp.votes = make(map[hash.Hash]bool)
 	sp.voteBySelf(b1, b2)
 	sp.voteByUniqueFutureSet(b1, b2)
 
 	return tiebreak, nil
 }
 
+// Vote between two blocks in the anticone of each other by the whole DAG,
+// true means the first block precedes the second.
 func (sp *Spectre) Vote(b1 IBlock, b2 IBlock) (bool, error) {
-
 	if v, err := sp.InitVote(b1, b2); err != nil {
 		return v, err
 	}
-
-	// For any node n in voted notes, if any child c of n hasn't voted, then add c into outer
-	// Outer is the outside layer of the voted notes, which means not all of its children have voted,
-	// so we start voting with these pending voting children. Once they have all voted, their children will become
-	// the new outside layer, so on and so forth, until all nodes have voted.
-	// note: tips are also outside layer, even though they have no children
-
-	outer := util.NewIterativeQueue()
-	for h := range sp.votes {
-		children := sp.bd.getBlock(&h).GetChildren()
-		if children == nil { // tips
-			outer.Enqueue(h)
-		} else { // haven't voted children
-			for _, ch := range children.GetMap() {
-				if !sp.hasVoted(*ch.(IBlock).GetHash()) {
-					outer.Enqueue(h)
-					break
-				}
-			}
-		}
-	}
-
-	for outer.Len() > 0 {
-		any := outer.Dequeue().(hash.Hash)
-
-		// having all children of some outer node voted or not, if this is true, that node can be dequeued
-		done := true
-		children := sp.bd.getBlock(&any).GetChildren()
-		if children == nil || children.Size() == 0 { // tips
-			// game over once all tips have voted
-			if win, err := sp.VoteByBlock(nil); err == nil {
-				return win, nil
-			}
-			// go on when some tip hasn't voted
-			continue
-		}
-
-		for _, ch := range children.GetMap() {
-			if sp.hasVoted(*ch.(IBlock).GetHash()) {
-				continue
-			}
-			all := true // all parented voted
-			chParents := sp.bd.getBlock(ch.(IBlock).GetHash()).GetParents()
-			for _, ph := range chParents.GetMap() {
-				// note: must ignore dangling parent,
-				// e.g. in figure ByteBall2, 7 is a dangling node, so once 17 and 21 are voted, 24 is able to vote
-				if !sp.hasVoted(*ph.(IBlock).GetHash()) && !sp.dangling.Has(ph.(IBlock).GetHash()) {
-					all = false
-					break
-				}
-			}
-			if all {
-				vb := sp.bd.getBlock(ch.(IBlock).GetHash())
-				sp.VoteByBlock(vb)
-				outer.Enqueue(ch)
-			} else {
-				done = false
-			}
-		}
-
-		// needs processing again
-		if !done {
-			outer.Enqueue(any)
-		}
-
-		// if all the outer nodes vote unanimously, then no need to come up with the rest nodes,
-		// because they will inherit the result of outer nodes
-		// e.g. in figure ByteBall2, once 17, 21, 20 have voted the same candidate,
-		// then 19, 22~27 should follow their votes
-		consistent := true
-		first := outer.Peek().(hash.Hash)
-		vote := sp.votes[first]
-		for _, o := range outer {
-			if sp.votes[o.(hash.Hash)] != vote {
-				consistent = false
-				break
-			}
-		}
-		if consistent {
-			return vote, nil
-		}
-	}
-
-	return sp.candidate1.GetHash().String() < sp.candidate2.GetHash().String(), nil
+	return sp.VoteByBlock(nil)
 }
 
-//  TODO: test if there is ancestor-descendant relationship between b1 and b2
+// Whether b1 is in the past of b2.
 func (sp *Spectre) IsInPastOf(b1 IBlock, b2 IBlock) bool {
-	return false
+	return sp.pasts[b2.GetID()].has(b1.GetID())
 }
 
-// intersection of virtual block (if not nil) with its past set and voted nodes,
-// note the DIRECTION IS REVERSED than the original graph, which means virtual block is genesis
-func (sp *Spectre) votedPast(virtualBlock IBlock) *BlockDAG {
-	q := util.NewIterativeQueue()
-
-	if virtualBlock == nil {
-		for ht := range sp.bd.tips.GetMap() {
-			q.Enqueue(ht)
-		}
-	} else {
-		q.Enqueue(*virtualBlock.GetHash())
-	}
-
-	visited := NewHashSet()
-	// cache the intersection of past set and voted notes
-	cache := make(map[hash.Hash]IBlock)
-	for q.Len() > 0 {
-		h := q.Dequeue().(hash.Hash)
-
-		if visited.Has(&h) {
-			continue
-		} else {
-			visited.Add(&h)
-		}
-		hParents := sp.bd.getBlock(&h).GetParents()
-		for _, ib := range hParents.GetMap() {
-			ph := *ib.(IBlock).GetHash()
-			if sp.dangling.Has(&ph) {
-				continue
-			}
-			if sp.hasVoted(ph) {
-				if !visited.Has(&ph) {
-					q.Enqueue(ph)
-				}
-
-				// must cache block  due to children index
-				sb, ok := cache[ph]
-				if !ok {
-					sb = &Block{hash: ph, parents: NewIdSet(), id: ib.(IBlock).GetID()}
-					cache[ph] = sb
-				}
-				sb.GetParents().AddPair(sp.bd.getBlock(&h).GetID(), sp.bd.getBlock(&h))
-			}
-		}
-	}
-
-	vh := hash.MustHexToDecodedHash(strconv.Itoa(int(sp.bd.blockTotal)))
+// The past set of virtual block, it's the whole DAG if virtual block is nil.
+func (sp *Spectre) votedPast(virtualBlock IBlock) idBits {
 	if virtualBlock != nil {
-		vh = *virtualBlock.GetHash()
+		return sp.pasts[virtualBlock.GetID()]
 	}
-	sb := &SpectreBlockData{hash: vh}
-	vp := &BlockDAG{}
-	vp.Init(spectre, nil, -1, nil, nil)
-	vp.AddBlock(sb)
-	visited = NewHashSet()
-
-	q = util.NewIterativeQueue()
-	if virtualBlock == nil {
-		for _, th := range sp.bd.tips.GetMap() {
-			sb := &SpectreBlockData{hash: *th.(IBlock).GetHash()}
-			sb.parents = []*hash.Hash{}
-			// create a virtual block as genesis
-			sb.parents = append(sb.parents, &vh)
-			vp.AddBlock(sb)
-			q.Enqueue(th)
-		}
-	} else {
-		q.Enqueue(vh)
+	past := idBits{}
+	for id := range sp.bd.blocks {
+		past.add(id)
 	}
-	for q.Len() > 0 {
-		pos := q.Dequeue().(hash.Hash)
-		visited.Add(&pos)
-		posParents := sp.bd.getBlock(&pos).GetParents()
-		for _, ib := range posParents.GetMap() {
-			ph := *ib.(IBlock).GetHash()
-			if !sp.hasVoted(ph) || sp.dangling.Has(&ph) {
-				continue
-			}
-			if visited.Has(&ph) {
-				continue
-			}
-
-			all := true
-			phChildren := sp.bd.getBlock(&ph).GetChildren()
-			for _, ch := range phChildren.GetMap() {
-				if _, ok := cache[*ch.(IBlock).GetHash()]; !ok {
-					continue
-				}
-				if !visited.Has(ch.(IBlock).GetHash()) {
-					all = false
-				}
-			}
-			// ensure that add ancestors before descendants
-			if all {
-				q.Enqueue(ph)
-				sp.newVoter(ph, vp)
-				visited.Add(&ph)
-			}
-		}
-	}
-
-	return vp
+	return past
 }
 
-// Update votes of any node in votedPast
-// Note: the ancestors of the node must have their votes updated since the node needs inherit those votes
-func (sp *Spectre) updateVotes(votedPast *BlockDAG, vh hash.Hash) bool {
-	// test if all parents being voted
-	canUpdate := true
-	maxVotes := -1
-	maxParent := new(SpectreBlock)
-
-	// increase votedPast with new nodes, only happening on updating votes in candidates' past sets
-	if !votedPast.hasBlockById(votedPast.getBlock(&vh).GetID()) {
-		vhChildren := sp.bd.getBlock(&vh).GetChildren()
-		for id, ch := range vhChildren.GetMap() {
-			if !votedPast.hasBlockById(id) && !sp.hasVoted(*ch.(IBlock).GetHash()) {
-				canUpdate = false
-				break
-			}
-		}
-		if canUpdate {
-			sp.newVoter(vh, votedPast)
-		} else {
-			return false
-		}
-	}
-	parents := votedPast.getBlock(&vh).GetParents()
-
-	if parents == nil || parents.Size() == 0 {
-		log.Error("no parents of ", vh)
-	}
-
-	// max parent has more nodes in its future set, which means more votes to inherit
-	for id, ph := range parents.GetMap() {
-		if ph.(IBlock).GetHash().IsEqual(votedPast.getGenesis().GetHash()) {
-			continue
-		}
-		b := votedPast.getBlockById(id)
-		sb := votedPast.instance.(*Spectre).sblocks[*b.GetHash()]
-		if sb.Votes1 < 0 || sb.Votes2 < 0 {
-			canUpdate = false
-			break
-		}
-		votes := sb.Votes2 + sb.Votes1
-		if votes > maxVotes {
-			maxVotes = votes
-			maxParent = sb
-		}
-	}
-
-	if canUpdate {
-		// first step, inherit votes from max voter
-		b := votedPast.getBlock(&vh)
-		voter := votedPast.instance.(*Spectre).sblocks[*b.GetHash()]
-
-		voter.Votes1, voter.Votes2 = maxParent.Votes1, maxParent.Votes2
-
-		// if it can be updated, it MUST be updated
-		if maxParent == nil || maxParent.hash.IsEqual(&hash.Hash{}) {
-			log.Error(vh.String())
-		}
+//2) if z ∈ G is in future (x)∩future (y) then z ’s vote will be determined recursively according to the DAG that is reduced to its past,
+// i.e., it has the same vote as virtual (past (z)). If the result of this vote is a tie, z breaks it arbitrarily.
 
-		// Note: results in s is constant, so we must reference them in the first place
-		// then we compare its votes between candidate 1 and 2
-		if sp.hasVoted(*maxParent.GetHash()) {
-			v := sp.votes[*maxParent.GetHash()]
-			if v {
-				voter.Votes1 += 1
+// If virtual block is nil, it is like an imaginary recent coming node which references all the tips and the whole graph
+// is its past set, otherwise it means that some real block is the virtual block of its own past set
+func (sp *Spectre) VoteByBlock(virtualBlock IBlock) (bool, error) {
+	if virtualBlock != nil && sp.hasVoted(*virtualBlock.GetHash()) {
+		return sp.votes[*virtualBlock.GetHash()], nil
+	}
+	past := sp.votedPast(virtualBlock)
+
+	// Every block votes after its future in the past set, so the blocks are
+	// visited from the last one added.
+	ids := past.list()
+	firsts, seconds := idBits{}, idBits{}
+	total := 0
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		b := sp.bd.getBlockById(id)
+		vote := 0
+		if sp.common.has(id) {
+			if first, err := sp.VoteByBlock(b); err != nil {
+				return false, err
+			} else if first {
+				vote = 1
 			} else {
-				voter.Votes2 += 1
+				vote = -1
 			}
-		} else {
-			if maxParent.Votes2 > maxParent.Votes1 {
-				voter.Votes2 += 1
-			} else if maxParent.Votes2 < maxParent.Votes1 {
-				voter.Votes1 += 1
-			}
-		}
-
-		// second, add votes from other voters
-		if b.GetParents().Size() > 1 {
-			sp.updateTipVotes(voter, maxParent, votedPast)
-		}
-	}
-	return canUpdate
-}
-
-// once we have inherited votes from max parent (the tip with max votes), we need add votes from other tips
-// because each tip and its future set have various nodes in common with another tip and the common part has been
-// calculated by inheriting the max parent, we only need to add votes of nodes which are exclusive in each tip
-
-// e.g. in ByteBall2, if virtual block is 21 and the intersection of its past set and candidates' future sets has voted,
-// which are 11~18 and 20 in this case. For 10,
-// 12 is the max parent (3 votes for candidate 1(c1), 4 votes for candidate 2(c2)), so we inherit its votes.
-// for the other tips, in this case it is only 13, and we find the nodes which are inside 13 and 13's future set but
-// outside 12 and 12's future set is 13, and 13 has voted c2. so the final votes of 10 would be 3 for c1 and 4 for c2
-
-func (sp *Spectre) updateTipVotes(voter *SpectreBlock, maxParent *SpectreBlock, votedPast *BlockDAG) {
-	vb := votedPast.getBlock(voter.GetHash())
-	voterParents := vb.GetParents()
-	tipStack := stack.New()
-	tipSet := NewHashSet()
-	// take out all other tips and add their votes to child
-	for _, h := range voterParents.GetMap() {
-		if !h.(IBlock).GetHash().IsEqual(maxParent.GetHash()) && !h.(IBlock).GetHash().IsEqual(votedPast.getGenesis().GetHash()) {
-			tipStack.Push(h)
-			tipSet.Add(h.(IBlock).GetHash())
-		}
-	}
-	for tipStack.Len() > 0 {
-		tipHash := tipStack.Pop().(hash.Hash)
-		tb := votedPast.getBlock(&tipHash)
-		tipVoter := votedPast.instance.(*Spectre).sblocks[*tb.GetHash()]
-		if sp.hasVoted(tipHash) {
-			v := sp.votes[tipHash]
+		} else if v, ok := sp.votes[*b.GetHash()]; ok {
 			if v {
-				voter.Votes1 += 1
+				vote = 1
 			} else {
-				voter.Votes2 += 1
-			}
-		} else {
-			if tipVoter.Votes2 > tipVoter.Votes1 {
-				voter.Votes2 += 1
-			} else if tipVoter.Votes2 < tipVoter.Votes1 {
-				voter.Votes1 += 1
+				vote = -1
 			}
-		}
-
-		// find nodes exclusively exist in one tip. We save all the nodes visited in tipSet, for a note's parent p,
-		// if all children of p exist in tipSet, p is exclusively in that node's future set.
-		// e.g. in ByteBall2 with 21 as the virtual block, from 10's view, if we want to find 12's exclusive future,
-		// we save 12 into tipSet first, the 14 and 15 are 12's exclusive parents since all their children
-		// (just 12 in this case ) exist in tipSet
-		for _, ib := range tb.GetParents().GetMap() {
-			tp := *ib.(IBlock).GetHash()
-			if tipSet.Has(&tp) {
-				continue
-			}
-			only := true
-			tpChildren := votedPast.getBlock(&tp).GetChildren()
-			for _, tc := range tpChildren.GetMap() {
-				if !tipSet.Has(tc.(IBlock).GetHash()) {
-					only = false
-					break
-				}
-			}
-			if only {
-				tipStack.Push(tp)
-				tipSet.Add(&tp)
-			}
-		}
-	}
-}
-func (sp *Spectre) followParents(virtualBlock IBlock) (bool, bool, error) {
-	// if all parents have voted in consistence, then just follow them
-	consistent := true
-	last := 0
-
-	parents := NewIdSet()
-	if virtualBlock == nil { // whole graph
-		parents = sp.bd.tips
-	} else { // past set of one node
-		parents = virtualBlock.GetParents()
-	}
-	for _, id := range parents.GetMap() {
-		ph := *id.(IBlock).GetHash()
-		if sp.dangling.Has(&ph) {
-			continue
-		}
-		if !sp.hasVoted(ph) {
-			return true, sp.candidate1.GetHash().String() < sp.candidate2.GetHash().String(), fmt.Errorf("parent %v not ready", ph)
-		}
-		vote := -1
-		if !sp.votes[ph] {
-			vote = 1
-		}
-		if last == 0 {
-			last = vote
 		} else {
-			if vote != last {
-				consistent = false
-				break
-			}
+			future := sp.futures[id]
+			vote = sp.voteByFutureSet(countAll(future, past, firsts), countAll(future, past, seconds))
 		}
-	}
-	firstWin := false
-	if consistent {
-		firstWin = last < 0
-		h := virtualBlock.GetHash()
-		if firstWin {
-			sp.voteFirst(*h)
-		} else {
-			sp.voteSecond(*h)
+		if vote > 0 {
+			firsts.add(id)
+		} else if vote < 0 {
+			seconds.add(id)
 		}
-		return true, firstWin, nil
-	}
-
-	return false, false, nil
-}
-
-//2) if z ∈ G is in future (x)∩future (y) then z ’s vote will be determined recursively according to the DAG that is reduced to its past,
-// i.e., it has the same vote as virtual (past (z)). If the result of this vote is a tie, z breaks it arbitrarily. 3
-
-// votedPast is a REVERSED graph, which means it starts from a virtual block and traverses backwards
-
-// If virtual block is nil, it is like an imaginary recent coming node which references all the tips and the whole graph
-// is its past set,  otherwise it means that some real block is the virtual block of its own past set
-func (sp *Spectre) VoteByBlock(virtualBlock IBlock) (bool, error) {
-	if ok, res, err := sp.followParents(virtualBlock); ok {
-		return res, err
+		total += vote
 	}
 
-	votedPast := sp.votedPast(virtualBlock)
-	// outer of votedPast, note the direction
-	outer := sp.voteFromFutureSet(votedPast)
-	firstWin := sp.voteFromPastSet(votedPast, outer)
-
 	//4) if z is the virtual block of G then it will vote the same way as the vote of the majority of blocks in G.
+	firstWin := total > 0
+	if total == 0 {
+		firstWin = sp.candidate1.GetHash().String() < sp.candidate2.GetHash().String()
+	}
 	if virtualBlock != nil {
 		h := virtualBlock.GetHash()
 		if firstWin {
@@ -542,221 +371,15 @@ func (sp *Spectre) VoteByBlock(virtualBlock IBlock) (bool, error) {
 
 //3) if z ∈ G is not in the future of either blocks then it will vote the same way as the vote of the majority of blocks in its own future.
 
-// Update vote numbers of votedPast, e.g. for any node n in votedPast, update its Votes1 and Votes2
-// note: because one node's vote number is dependent on its ancestor,
-// we need update them from genesis of votePast down to its tips
-func (sp *Spectre) voteFromFutureSet(votedPast *BlockDAG) *HashSet {
-	tips := NewHashSet()
-
-	vb := votedPast.getGenesis()
-	unvisited := util.NewIterativeQueue()
-	visited := NewHashSet()
-
-	vChildren := votedPast.getBlock(vb.GetHash()).GetChildren()
-	for _, ib := range vChildren.GetMap() {
-		ch := *ib.(IBlock).GetHash()
-		// only children with one parent (virtual block) will be selected as initial tips,
-		// because they are only dependent on their single parent and their votes can be updated directly
-		// e.g. note 22 in ByteBall2, 14, 20 can be initialized with 0 votes, but 15 cannot due to its multiple parents
-		if votedPast.getBlock(&ch).GetParents().Size() == 1 {
-			sb := votedPast.instance.(*Spectre).sblocks[ch]
-			sb.Votes1, sb.Votes2 = 0, 0
-
-			parents := sp.bd.getBlock(&ch).GetParents()
-			if parents != nil {
-				for ph := range parents.GetMap() {
-					if !votedPast.hasBlockById(ph) {
-						tips.Add(&ch)
-						break
-					}
-				}
-			} else {
-				if !ch.IsEqual(votedPast.getGenesis().GetHash()) {
-					log.Error("only virtual block can do without parents")
-				}
-			}
-			cChildren := votedPast.getBlock(&ch).GetChildren()
-			if cChildren != nil && cChildren.Size() > 0 {
-				unvisited.Enqueue(ch)
-			}
-		}
-	}
-
-	// update votes with BFS order
-	for unvisited.Len() > 0 {
-		n := unvisited.Dequeue().(hash.Hash)
-		childrenUpdated := true
-		nChildren := votedPast.getBlock(&n).GetChildren()
-		for _, id := range nChildren.GetMap() {
-			ch := *id.(IBlock).GetHash()
-			if !visited.Has(&ch) {
-				if sp.updateVotes(votedPast, ch) {
-					visited.Add(&ch)
-
-					parents := sp.bd.getBlock(&ch).GetParents()
-					if parents != nil {
-						for ph := range parents.GetMap() {
-							if !votedPast.hasBlockById(ph) {
-								tips.Add(&ch)
-								break
-							}
-						}
-					} else {
-						if !ch.IsEqual(votedPast.getGenesis().GetHash()) {
-							log.Error("only virtual block can do without parents")
-						}
-					}
-					children := votedPast.getBlock(&ch).GetChildren()
-					if children != nil && children.Size() > 0 {
-						unvisited.Enqueue(ch)
-					}
-				} else {
-					childrenUpdated = false
-				}
-			}
-		}
-		if !childrenUpdated {
-			unvisited.Enqueue(n)
-		}
-	}
-
-	return tips
-}
-
-// update votes of candidates's past sets
-// tips stand for nodes in votedPast with their children having not fully voted
-// and those children are called outer nodes.
-// e.g. BB2, VB is 21, 11, 12, 13 are tips, 9 and 10 are outer nodes
-
-// with outer nodes keep voting and become new tips, their children will become the new outer nodes
-// repeat this process until all the outer nodes votes for the same candidate, and VB will vote for him as well
-
-func (sp *Spectre) voteFromPastSet(votedPast *BlockDAG, tips *HashSet) bool {
-	unvisited := util.NewIterativeQueue()
-	outerNodes := NewHashSet()
-	for h := range tips.GetMap() {
-		hParents := sp.bd.getBlock(&h).GetParents()
-		for _, ib := range hParents.GetMap() {
-			ph := *ib.(IBlock).GetHash()
-			if !outerNodes.Has(&ph) && !votedPast.hasBlockById(ib.(IBlock).GetID()) {
-				unvisited.Enqueue(ph)
-				outerNodes.AddPair(&ph, ib)
-			}
-		}
-	}
-
-	for unvisited.Len() > 0 {
-		n := unvisited.Dequeue().(hash.Hash)
-
-		if !sp.updateVotes(votedPast, n) {
-			unvisited.Enqueue(n)
-		} else {
-			lastVote := 0
-			consistent := true
-			for o, ib := range outerNodes.GetMap() {
-				if !votedPast.hasBlockById(ib.(IBlock).GetID()) {
-					consistent = false
-					break
-				}
-				sp := votedPast.instance.(*Spectre).sblocks[o]
-				// we don't consider those with tie votes
-				if sp.Votes2 != sp.Votes1 {
-					spVote := -1
-					if sp.Votes1 < sp.Votes2 {
-						spVote = 1
-					}
-					if lastVote == 0 {
-						lastVote = spVote
-					} else {
-						if lastVote != spVote {
-							consistent = false
-							break
-						}
-					}
-				}
-			}
-
-			// consistent then follow the result
-			if consistent {
-				return lastVote < 0
-			} else {
-				removing := NewHashSet()
-				skip := NewHashSet()
-				for o, ib := range outerNodes.GetMap() {
-					if skip.Has(&o) {
-						continue
-					}
-					allUpdated := votedPast.hasBlockById(ib.(IBlock).GetID())
-					oParents := sp.bd.getBlock(&o).GetParents()
-					for _, oib := range oParents.GetMap() {
-						ph := *oib.(IBlock).GetHash()
-						if !sp.updateVotes(votedPast, ph) {
-							allUpdated = false
-							break
-						} else if outerNodes.Has(&ph) {
-							skip.Add(&ph)
-						}
-					}
-
-					if allUpdated {
-						removing.Add(&o)
-					}
-				}
-
-				for r := range removing.GetMap() {
-					outerNodes.Remove(&r)
-					rChildren := votedPast.getBlock(&r).GetChildren()
-					for _, c := range rChildren.GetMap() {
-						if !outerNodes.Has(c.(IBlock).GetHash()) {
-							outerNodes.Add(c.(IBlock).GetHash())
-							unvisited.Enqueue(c)
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// break the tie
-	return sp.candidate1.GetHash().String() < sp.candidate2.GetHash().String()
-}
-
-// add voter into voted past set
-func (sp *Spectre) newVoter(vh hash.Hash, votedPast *BlockDAG) IBlock {
-	sb := SpectreBlockData{hash: vh}
-	sb.parents = []*hash.Hash{}
-	vhChildren := sp.bd.getBlock(&vh).GetChildren()
-	for _, ib := range vhChildren.GetMap() {
-		hash := *ib.(IBlock).GetHash()
-		if votedPast.hasBlockById(ib.(IBlock).GetID()) {
-			sb.parents = append(sb.parents, &hash)
-		}
-	}
-	if votedPast.hasBlockById(votedPast.getBlock(&vh).GetID()) {
-		log.Error("has already voter ", vh)
+// The votes for either candidate in the future set must be given, a tie
+// doesn't vote.
+func (sp *Spectre) voteByFutureSet(firsts int, seconds int) int {
+	if firsts > seconds {
+		return 1
+	} else if firsts < seconds {
+		return -1
 	}
-	//votedPast.AddBlock(&sb)
-	block := Block{hash: *sb.GetHash(), weight: 1}
-	if sb.parents != nil {
-		block.parents = NewIdSet()
-		for _, h := range sb.parents {
-			hash := *h
-			block.parents.Add(votedPast.getBlock(&hash).GetID())
-			parent := votedPast.getBlock(&hash)
-			parent.AddChild(&block)
-		}
-	}
-	if votedPast.blocks == nil {
-		votedPast.blocks = map[uint]IBlock{}
-	}
-	votedPast.blocks[block.id] = &block
-	if votedPast.blockTotal == 0 {
-		votedPast.genesis = *block.GetHash()
-	}
-	votedPast.blockTotal++
-	votedPast.updateTips(&block)
-	votedPast.instance.AddBlock(&block)
-	return &block
+	return 0
 }
 
 //5) finally, (for the case where z equals x or y ), z votes for itself to succeed any block in past (z) and to precede any block outside past (z).
@@ -765,44 +388,23 @@ func (sp *Spectre) voteBySelf(b1 IBlock, b2 IBlock) {
 	sp.voteSecond(*b2.GetHash())
 }
 
-// 1) if z ∈ G is in future (x) but not in future (y) then it will vote in favour of x (i.e., for x ≺y ).
+// 1) if z ∈ G is in future (x) but not in future (y) then it will vote in favour of x (i.e., for x ≺y ).
 func (sp *Spectre) voteByUniqueFutureSet(b1 IBlock, b2 IBlock) {
-	fs1 := NewIdSet()
-	sp.bd.getFutureSet(fs1, b1)
-
-	fs2 := NewIdSet()
-	sp.bd.getFutureSet(fs2, b2)
+	fs1 := sp.futures[b1.GetID()]
+	fs2 := sp.futures[b2.GetID()]
 
-	for hf, ibf := range fs1.GetMap() {
-		hfParents := sp.bd.getBlockById(hf).GetParents()
-		for h, ib := range hfParents.GetMap() {
-			if !fs1.Has(h) && !fs2.Has(h) {
-				sp.dangling.Add(ib.(IBlock).GetHash())
-			}
-		}
-		if fs2.Has(hf) {
-			fs2.Remove(hf)
+	sp.common = idBits{}
+	for _, id := range fs1.list() {
+		if fs2.has(id) {
+			sp.common.add(id)
 		} else {
-			sp.voteFirst(*ibf.(IBlock).GetHash())
+			sp.voteFirst(*sp.bd.getBlockById(id).GetHash())
 		}
 	}
-
-	for _, ib := range fs2.GetMap() {
-		hfParents := ib.(IBlock).GetParents()
-		for h, ibp := range hfParents.GetMap() {
-			if !fs1.Has(h) && !fs2.Has(h) {
-				sp.dangling.Add(ibp.(IBlock).GetHash())
-			}
+	for _, id := range fs2.list() {
+		if !fs1.has(id) {
+			sp.voteSecond(*sp.bd.getBlockById(id).GetHash())
 		}
-		sp.voteSecond(*ib.(IBlock).GetHash())
-	}
-	sp.dangling.Remove(b1.GetHash())
-	sp.dangling.Remove(b2.GetHash())
-	for _, ib := range b1.GetParents().GetMap() {
-		sp.dangling.Remove(ib.(IBlock).GetHash())
-	}
-	for _, ib := range b2.GetParents().GetMap() {
-		sp.dangling.Remove(ib.(IBlock).GetHash())
 	}
 }
 
@@ -813,7 +415,11 @@ func (sp *Spectre) IsOnMainChain(b IBlock) bool {
 
 // return the tip of main chain
 func (sp *Spectre) GetMainChainTip() IBlock {
-	return nil
+	tips := sp.GetTipsList()
+	if len(tips) == 0 {
+		return nil
+	}
+	return tips[0]
 }
 
 // return the main parent in the parents
@@ -2,7 +2,10 @@ package blockdag
 
 import (
 	"fmt"
+	"math/rand"
+	"reflect"
 	"sort"
+	"testing"
 )
 
 func Log(sp *Spectre) {
@@ -40,3 +43,137 @@ func TestSpectre1(t *testing.T) {
 		}
 	}
 }*/
+
+func Test_SpectreOrder(t *testing.T) {
+	for _, graph := range []string{"PH_fig2-blocks", "PH_fig4-blocks", "CO_Blocks", "SP_Blocks", "CP_Blocks"} {
+		ibd := InitBlockDAG(spectre, graph)
+		if ibd == nil {
+			t.Fatalf("%s: failed to build DAG", graph)
+		}
+		sp := ibd.(*Spectre)
+		ordered := NewIdSet()
+		for o := uint(0); o < bd.blockTotal; o++ {
+			h := sp.GetBlockByOrder(o)
+			if h == nil {
+				t.Fatalf("%s: no block at order %d", graph, o)
+			}
+			b := bd.getBlock(h)
+			if b.GetOrder() != o {
+				t.Fatalf("%s: block %s has order %d, expect %d", graph, getBlockTag(b.GetID()), b.GetOrder(), o)
+			}
+			if b.GetParents() != nil {
+				for _, pid := range b.GetParents().List() {
+					if !ordered.Has(pid) {
+						t.Fatalf("%s: block %s precedes its parent %s", graph, getBlockTag(b.GetID()), getBlockTag(pid))
+					}
+				}
+			}
+			ordered.Add(b.GetID())
+		}
+		if ordered.Size() != int(bd.blockTotal) {
+			t.Fatalf("%s: ordered %d blocks, expect %d", graph, ordered.Size(), bd.blockTotal)
+		}
+
+		tips := sp.GetTipsList()
+		if len(tips) != bd.tips.Size() || tips[0] != sp.GetMainChainTip() {
+			t.Fatalf("%s: unexpected tips", graph)
+		}
+		mainChain := sp.GetMainChain()
+		if mainChain[len(mainChain)-1] != 0 {
+			t.Fatalf("%s: main chain doesn't end at genesis", graph)
+		}
+	}
+}
+
+func Test_SpectreIncrementalOrder(t *testing.T) {
+	bd = BlockDAG{}
+	sp := bd.Init(spectre, CalcBlockWeight, -1, onGetBlockId, nil).(*Spectre)
+	r := rand.New(rand.NewSource(7))
+	for i := 0; i < 120; i++ {
+		parents := NewIdSet()
+		if i > 0 {
+			// Pick from the recent blocks so that some are in the anticone.
+			window := i
+			if window > 6 {
+				window = 6
+			}
+			for j := r.Intn(3); j >= 0; j-- {
+				parents.Add(uint(i - 1 - r.Intn(window)))
+			}
+		}
+		if i%10 == 9 {
+			// Now and then a block is in the future of all others.
+			parents = bd.tips.Clone()
+		}
+		_, ib := bd.AddBlock(buildBlock(parents))
+		if ib == nil {
+			parents = NewIdSet()
+			parents.Add(bd.tips.SortList(false)[0])
+			if _, ib = bd.AddBlock(buildBlock(parents)); ib == nil {
+				t.Fatalf("failed to add block %d", i)
+			}
+		}
+		order := map[uint]uint{}
+		for o, id := range bd.order {
+			order[o] = id
+		}
+		if changed := sp.orderFrom(0, ib); len(changed) != 0 {
+			t.Fatalf("block %d: incremental order differs from full order at %d", i, bd.getBlockById(changed[0]).GetOrder())
+		}
+		if !reflect.DeepEqual(order, bd.order) {
+			t.Fatalf("block %d: incremental order differs from full order", i)
+		}
+	}
+}
+
+// X has less blocks in its own future than Y, but the blocks after Z see only
+// X1 and Y in their past, so they vote for X by the recursive vote and X wins.
+func Test_SpectreVote(t *testing.T) {
+	bd = BlockDAG{}
+	sp := bd.Init(spectre, CalcBlockWeight, -1, onGetBlockId, nil).(*Spectre)
+	blocks := map[string]IBlock{}
+	add := func(tag string, parents ...string) {
+		ps := NewIdSet()
+		for _, parent := range parents {
+			ps.Add(blocks[parent].GetID())
+		}
+		_, ib := bd.AddBlock(buildBlock(ps))
+		if ib == nil {
+			t.Fatalf("failed to add block %s", tag)
+		}
+		blocks[tag] = ib
+	}
+	add("G")
+	add("X", "G")
+	add("Y", "G")
+	add("X1", "X")
+	add("Y1", "Y")
+	add("Y2", "Y1")
+	add("Z", "X1", "Y")
+	add("Z1", "Z")
+	add("Z2", "Z1")
+	add("Z3", "Z2")
+
+	first, err := sp.Vote(blocks["X"], blocks["Y"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first {
+		t.Fatal("expect X precedes Y")
+	}
+	// G is in the future of neither, so its vote isn't kept.
+	expect := map[string]bool{"X": true, "X1": true, "Z": true, "Z1": true, "Z2": true, "Z3": true,
+		"Y": false, "Y1": false, "Y2": false}
+	votes := sp.Votes()
+	if len(votes) != len(expect) {
+		t.Fatalf("expect %d votes, but %d", len(expect), len(votes))
+	}
+	for tag, vote := range expect {
+		if v, ok := votes[*blocks[tag].GetHash()]; !ok || v != vote {
+			t.Fatalf("expect %s votes %v", tag, vote)
+		}
+	}
+	if blocks["X"].GetOrder() != 1 || blocks["Y"].GetOrder() != 3 {
+		t.Fatalf("expect X and Y at order 1 and 3, but %d and %d", blocks["X"].GetOrder(), blocks["Y"].GetOrder())
+	}
+}
@@ -1,6 +1,9 @@
 package blockdag
 
-import "github.com/Qitmeer/qitmeer/common/hash"
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"math/bits"
+)
 
 type ISpectre interface {
 	Vote(x IBlock, y IBlock) int
@@ -38,3 +41,55 @@ func (sd *SpectreBlockData) GetTimestamp() int64 {
 func (sd *SpectreBlockData) GetWeight() uint64 {
 	return 1
 }
+
+// The ids of a set of blocks as bits, the past and future sets of blocks are
+// kept this way for the votes.
+type idBits []uint64
+
+func (s idBits) has(id uint) bool {
+	i := id / 64
+	return i < uint(len(s)) && s[i]&(1<<(id%64)) != 0
+}
+
+func (s *idBits) add(id uint) {
+	for uint(len(*s)) <= id/64 {
+		*s = append(*s, 0)
+	}
+	(*s)[id/64] |= 1 << (id % 64)
+}
+
+func (s *idBits) addSet(other idBits) {
+	for len(*s) < len(other) {
+		*s = append(*s, 0)
+	}
+	for i, word := range other {
+		(*s)[i] |= word
+	}
+}
+
+func (s idBits) list() []uint {
+	result := []uint{}
+	for i, word := range s {
+		for ; word != 0; word &= word - 1 {
+			result = append(result, uint(i*64+bits.TrailingZeros64(word)))
+		}
+	}
+	return result
+}
+
+func (s idBits) size() int {
+	n := 0
+	for _, word := range s {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// The number of ids in all three sets.
+func countAll(a, b, c idBits) int {
+	n := 0
+	for i := 0; i < len(a) && i < len(b) && i < len(c); i++ {
+		n += bits.OnesCount64(a[i] & b[i] & c[i])
+	}
+	return n
+}
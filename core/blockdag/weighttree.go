@@ -0,0 +1,161 @@
+package blockdag
+
+// The weights of blocks of Conflux on the tree of main parents. A block that
+// is the main parent of others weighs one more than the sum of their weights,
+// so every main ancestor of a block gets one heavier when the block becomes a
+// main parent. The tree is a link-cut tree, adding to the path from a block
+// to genesis and reading the weight of a block take amortized logarithmic
+// time however deep DAG is.
+type weightTree struct {
+	nodes map[uint]*weightNode
+
+	// The nodes whose weights are pushed down by splay
+	stack []*weightNode
+}
+
+// The node of a block in the splay tree of a path, the path is ordered from
+// genesis. The root of a splay tree points to the node its path hangs from.
+type weightNode struct {
+	parent   *weightNode
+	children [2]*weightNode
+	weight   int64
+
+	// The weight still to be added to the children
+	add int64
+}
+
+// Add a block under its main parent with the given weight, the main parent
+// is unknown for genesis.
+func (t *weightTree) link(id uint, mainParent uint, weight uint64) {
+	if t.nodes == nil {
+		t.nodes = map[uint]*weightNode{}
+	}
+	n := &weightNode{weight: int64(weight)}
+	if parent, ok := t.nodes[mainParent]; ok {
+		n.parent = parent
+	}
+	t.nodes[id] = n
+}
+
+// Remove a block that isn't the main parent of any block.
+func (t *weightTree) cut(id uint) {
+	n, ok := t.nodes[id]
+	if !ok {
+		return
+	}
+	t.access(n)
+	if ancestors := n.children[0]; ancestors != nil {
+		ancestors.parent = nil
+		n.children[0] = nil
+	}
+	delete(t.nodes, id)
+}
+
+// Add delta to the weights of a block and all its main ancestors.
+func (t *weightTree) addPath(id uint, delta int64) {
+	n, ok := t.nodes[id]
+	if !ok {
+		return
+	}
+	t.access(n)
+	n.weight += delta
+	n.add += delta
+}
+
+func (t *weightTree) get(id uint) uint64 {
+	n, ok := t.nodes[id]
+	if !ok {
+		return 0
+	}
+	t.access(n)
+	return uint64(n.weight)
+}
+
+// Set the weight of a block alone, its ancestors are left as they are.
+func (t *weightTree) set(id uint, weight uint64) {
+	n, ok := t.nodes[id]
+	if !ok {
+		return
+	}
+	t.access(n)
+	n.weight = int64(weight)
+}
+
+func (t *weightTree) reset() {
+	t.nodes = nil
+	t.stack = nil
+}
+
+// Make the path from genesis to the node the one of its splay tree, with the
+// node at the root.
+func (t *weightTree) access(n *weightNode) {
+	var last *weightNode
+	for x := n; x != nil; x = x.parent {
+		t.splay(x)
+		x.children[1] = last
+		last = x
+	}
+	t.splay(n)
+}
+
+func (t *weightTree) splay(x *weightNode) {
+	t.stack = append(t.stack[:0], x)
+	for y := x; !y.isRoot(); y = y.parent {
+		t.stack = append(t.stack, y.parent)
+	}
+	for i := len(t.stack) - 1; i >= 0; i-- {
+		t.stack[i].push()
+	}
+	for !x.isRoot() {
+		p := x.parent
+		if !p.isRoot() {
+			if (p.parent.children[0] == p) == (p.children[0] == x) {
+				p.rotate()
+			} else {
+				x.rotate()
+			}
+		}
+		x.rotate()
+	}
+}
+
+func (n *weightNode) isRoot() bool {
+	return n.parent == nil || (n.parent.children[0] != n && n.parent.children[1] != n)
+}
+
+func (n *weightNode) push() {
+	if n.add == 0 {
+		return
+	}
+	for _, c := range n.children {
+		if c != nil {
+			c.weight += n.add
+			c.add += n.add
+		}
+	}
+	n.add = 0
+}
+
+// Rotate the node above its parent, the weights on the way must be pushed.
+func (n *weightNode) rotate() {
+	p := n.parent
+	g := p.parent
+	dir := 0
+	if p.children[1] == n {
+		dir = 1
+	}
+	if !p.isRoot() {
+		if g.children[0] == p {
+			g.children[0] = n
+		} else {
+			g.children[1] = n
+		}
+	}
+	n.parent = g
+	p.children[dir] = n.children[1-dir]
+	if c := p.children[dir]; c != nil {
+		c.parent = p
+	}
+	n.children[1-dir] = p
+	p.parent = n
+}
@@ -0,0 +1,58 @@
+package blockdag
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Add to random paths of a random tree and remove random leaves, the weights
+// are compared with the ones added to every ancestor one by one.
+func Test_WeightTree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tree := weightTree{}
+	parents := map[uint]uint{0: MaxId}
+	weights := map[uint]uint64{0: 0}
+	children := map[uint]int{}
+	tree.link(0, MaxId, 0)
+	for id := uint(1); id < 2000; id++ {
+		// Remove a leaf now and then
+		if r.Intn(5) == 0 {
+			leaf := uint(r.Intn(int(id)))
+			if _, ok := parents[leaf]; ok && leaf != 0 && children[leaf] == 0 {
+				tree.cut(leaf)
+				children[parents[leaf]]--
+				delete(parents, leaf)
+				delete(weights, leaf)
+			}
+		}
+		parent := uint(r.Intn(int(id)))
+		if _, ok := parents[parent]; !ok {
+			parent = 0
+		}
+		tree.link(id, parent, 0)
+		parents[id] = parent
+		weights[id] = 0
+		children[parent]++
+
+		delta := int64(r.Intn(3)) - 1
+		at := uint(r.Intn(int(id) + 1))
+		if _, ok := parents[at]; !ok || (delta < 0 && weights[at] == 0) {
+			continue
+		}
+		tree.addPath(at, delta)
+		for p := at; p != MaxId; p = parents[p] {
+			weights[p] = uint64(int64(weights[p]) + delta)
+		}
+		for i := 0; i < 5; i++ {
+			check := uint(r.Intn(int(id) + 1))
+			if _, ok := parents[check]; ok && tree.get(check) != weights[check] {
+				t.Fatalf("the weight of %d is %d, expect %d", check, tree.get(check), weights[check])
+			}
+		}
+	}
+	for id, weight := range weights {
+		if tree.get(id) != weight {
+			t.Fatalf("the weight of %d is %d, expect %d", id, tree.get(id), weight)
+		}
+	}
+}
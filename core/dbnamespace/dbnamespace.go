@@ -68,6 +68,10 @@ var (
 	// dag information
 	DagInfoBucketName = []byte("daginfo")
 
+	// DagTipsBucketName is the name of the db bucket used to house the
+	// tips of dag
+	DagTipsBucketName = []byte("dagtips")
+
 	// CacheInvalidTx is the name of the db bucket used to cache invalid tx
 	CacheInvalidTxName = []byte("cacheinvalidtx")
 )
@@ -109,6 +109,10 @@ type Engine struct {
 	flags       ScriptFlags
 	version     uint16
 	bip16       bool // treat execution as pay-to-script-hash
+
+	// maxRedeemOps bounds the non-push operations of a pay-to-script-hash
+	// redeem script when it is positive.
+	maxRedeemOps int
 }
 
 // hasFlag returns whether the script engine instance has the passed flag set.
@@ -144,8 +148,8 @@ func (vm *Engine) executeOpcode(pop *ParsedOpcode) error {
 	// Note that this includes OP_RESERVED which counts as a push operation.
 	if pop.opcode.value > OP_16 {
 		vm.numOps++
-		if vm.numOps > MaxOpsPerScript {
-			return ErrStackTooManyOperations
+		if err := vm.checkNumOps(); err != nil {
+			return err
 		}
 
 	} else if len(pop.data) > MaxScriptElementSize {
@@ -263,6 +267,33 @@ func (vm *Engine) CheckErrorCondition(finalScript bool) error {
 	return nil
 }
 
+// SetMaxRedeemScriptOps limits the number of non-push operations a
+// pay-to-script-hash redeem script may execute, ErrRedeemScriptLimit is
+// returned once the limit is exceeded.  A value of zero only applies the usual
+// MaxOpsPerScript.  The limit is discarded by Reset, so it must be set after
+// the engine has been initialized.
+func (vm *Engine) SetMaxRedeemScriptOps(maxOps int) {
+	vm.maxRedeemOps = maxOps
+}
+
+// isRedeemScript returns whether the engine is executing the redeem script of
+// a pay-to-script-hash output.
+func (vm *Engine) isRedeemScript() bool {
+	return vm.bip16 && vm.scriptIdx == 2
+}
+
+// checkNumOps returns an error if the current script has executed more
+// operations than allowed.
+func (vm *Engine) checkNumOps() error {
+	if vm.numOps > MaxOpsPerScript {
+		return ErrStackTooManyOperations
+	}
+	if vm.maxRedeemOps > 0 && vm.isRedeemScript() && vm.numOps > vm.maxRedeemOps {
+		return ErrRedeemScriptLimit
+	}
+	return nil
+}
+
 // Step will execute the next instruction and move the program counter to the
 // next opcode in the script, or the next script if the current has ended.  Step
 // will return true in the case that the last opcode was successfully executed.
@@ -604,9 +635,30 @@ func (vm *Engine) SetAltStack(data [][]byte) {
 func NewEngine(scriptPubKey []byte, tx *types.Transaction, txIdx int,
 	flags ScriptFlags, scriptVersion uint16, sigCache *SigCache) (*Engine, error) {
 
+	vm := &Engine{}
+	err := vm.Reset(scriptPubKey, tx, txIdx, flags, scriptVersion, sigCache)
+	if err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+// Reset reinitializes the script engine for the provided public key script,
+// transaction, and input index exactly as NewEngine would, while retaining
+// the capacity of the internal stacks and script slices so that one engine
+// can be reused across many inputs without reallocating its state.
+//
+// All execution state from any previous use is discarded, so nothing can leak
+// from one validated input into the next.  The engine must not be used if an
+// error is returned until it has been successfully reset again.
+func (vm *Engine) Reset(scriptPubKey []byte, tx *types.Transaction, txIdx int,
+	flags ScriptFlags, scriptVersion uint16, sigCache *SigCache) error {
+
+	vm.clear()
+
 	// The provided transaction input index must refer to a valid input.
 	if txIdx < 0 || txIdx >= len(tx.TxIn) {
-		return nil, ErrInvalidIndex
+		return ErrInvalidIndex
 	}
 	scriptSig := tx.TxIn[txIdx].SignScript
 
@@ -618,15 +670,17 @@ func NewEngine(scriptPubKey []byte, tx *types.Transaction, txIdx int,
 	// allowing the clean stack flag without the P2SH flag would make it
 	// possible to have a situation where P2SH would not be a soft fork when
 	// it should be.
-	vm := Engine{version: scriptVersion, flags: flags, sigCache: sigCache}
+	vm.version = scriptVersion
+	vm.flags = flags
+	vm.sigCache = sigCache
 	if vm.hasFlag(ScriptVerifyCleanStack) && !vm.hasFlag(ScriptBip16) {
-		return nil, ErrInvalidFlags
+		return ErrInvalidFlags
 	}
 
 	// The signature script must only contain data pushes when the
 	// associated flag is set.
 	if vm.hasFlag(ScriptVerifySigPushOnly) && !IsPushOnlyScript(scriptSig) {
-		return nil, ErrStackNonPushOnly
+		return ErrStackNonPushOnly
 	}
 
 	// Subscripts for pay to script hash outputs are not allowed
@@ -635,7 +689,7 @@ func NewEngine(scriptPubKey []byte, tx *types.Transaction, txIdx int,
 		err := HasP2SHScriptSigStakeOpCodes(scriptVersion, scriptSig,
 			scriptPubKey)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -644,16 +698,15 @@ func NewEngine(scriptPubKey []byte, tx *types.Transaction, txIdx int,
 	// with a pay-to-script-hash transaction, there will be ultimately be
 	// a third script to execute.
 	scripts := [][]byte{scriptSig, scriptPubKey}
-	vm.scripts = make([][]ParsedOpcode, len(scripts))
-	for i, scr := range scripts {
+	for _, scr := range scripts {
 		if len(scr) > maxScriptSize {
-			return nil, ErrStackLongScript
+			return ErrStackLongScript
 		}
-		var err error
-		vm.scripts[i], err = parseScript(scr)
+		pops, err := parseScript(scr)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		vm.scripts = append(vm.scripts, pops)
 	}
 
 	// Advance the program counter to the public key script if the signature
@@ -666,7 +719,7 @@ func NewEngine(scriptPubKey []byte, tx *types.Transaction, txIdx int,
 	if vm.hasFlag(ScriptBip16) && isAnyKindOfScriptHash(vm.scripts[1]) {
 		// Only accept input scripts that push data for P2SH.
 		if !isPushOnly(vm.scripts[0]) {
-			return nil, ErrStackP2SHNonPushOnly
+			return ErrStackP2SHNonPushOnly
 		}
 		vm.bip16 = true
 	}
@@ -678,7 +731,32 @@ func NewEngine(scriptPubKey []byte, tx *types.Transaction, txIdx int,
 	vm.tx = *tx
 	vm.txIdx = txIdx
 
-	return &vm, nil
+	return nil
+}
+
+// clear discards all execution state of the engine while keeping the
+// allocated capacity of its slices for reuse.
+func (vm *Engine) clear() {
+	for i := range vm.scripts {
+		vm.scripts[i] = nil
+	}
+	vm.scripts = vm.scripts[:0]
+	vm.savedFirstStack = nil
+	vm.sigCache = nil
+	vm.scriptIdx = 0
+	vm.scriptOff = 0
+	vm.lastCodeSep = 0
+	vm.dstack.reset()
+	vm.astack.reset()
+	vm.tx = types.Transaction{}
+	vm.scriptTx = nil
+	vm.txIdx = 0
+	vm.condStack = vm.condStack[:0]
+	vm.numOps = 0
+	vm.flags = 0
+	vm.version = 0
+	vm.bip16 = false
+	vm.maxRedeemOps = 0
 }
 
 // NewEngine2 (refactor of NewEngine)
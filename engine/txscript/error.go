@@ -69,6 +69,10 @@ var (
 	// MaxOpsPerScript opcodes that do not push data.
 	ErrStackTooManyOperations = errors.New("too many operations in script")
 
+	// ErrRedeemScriptLimit is returned if a pay-to-script-hash redeem script
+	// executes more operations than the engine has been limited to.
+	ErrRedeemScriptLimit = errors.New("redeem script resource limit exceeded")
+
 	// ErrStackElementTooBig is returned if the size of an element to be
 	// pushed to the stack is over MaxScriptElementSize.
 	ErrStackElementTooBig = errors.New("element in script too large")
@@ -2677,8 +2677,8 @@ func opcodeCheckMultiSig(op *ParsedOpcode, vm *Engine) error {
 		return ErrStackTooManyPubKeys
 	}
 	vm.numOps += numPubKeys
-	if vm.numOps > MaxOpsPerScript {
-		return ErrStackTooManyOperations
+	if err := vm.checkNumOps(); err != nil {
+		return err
 	}
 
 	pubKeys := make([][]byte, 0, numPubKeys)
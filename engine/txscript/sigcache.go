@@ -9,6 +9,7 @@ package txscript
 import (
 	"bytes"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/crypto/ecc"
@@ -36,11 +37,27 @@ type sigCacheEntry struct {
 // optimization which speeds up the validation of transactions within a block,
 // if they've already been seen and verified within the mempool.
 type SigCache struct {
+	// The counters are accessed atomically, they are kept at the beginning
+	// of the struct for the 64-bit alignment.
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
 	sync.RWMutex
 	validSigs  map[hash.Hash]sigCacheEntry
 	maxEntries uint
 }
 
+// SigCacheStats is a snapshot of the statistics of a SigCache, it helps to
+// tune the size of cache.
+type SigCacheStats struct {
+	Entries    uint
+	MaxEntries uint
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+}
+
 // NewSigCache creates and initializes a new instance of SigCache. Its sole
 // parameter 'maxEntries' represents the maximum number of entries allowed to
 // exist in the SigCache at any particular moment. Random entries are evicted
@@ -63,10 +80,16 @@ func (s *SigCache) Exists(sigHash hash.Hash, sig ecc.Signature, pubKey ecc.Publi
 	entry, ok := s.validSigs[sigHash]
 	s.RUnlock()
 
-	return ok &&
+	exists := ok &&
 		bytes.Equal(entry.pubKey.SerializeCompressed(),
 			pubKey.SerializeCompressed()) &&
 		bytes.Equal(entry.sig.Serialize(), sig.Serialize())
+	if exists {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+	return exists
 }
 
 // Add adds an entry for a signature over 'sigHash' under public key 'pubKey'
@@ -98,8 +121,26 @@ func (s *SigCache) Add(sigHash hash.Hash, sig ecc.Signature, pubKey ecc.PublicKe
 		// entry.
 		for sigEntry := range s.validSigs {
 			delete(s.validSigs, sigEntry)
+			atomic.AddUint64(&s.evictions, 1)
 			break
 		}
 	}
 	s.validSigs[sigHash] = sigCacheEntry{sig, pubKey}
 }
+
+// Stats returns the current statistics of the SigCache.
+//
+// NOTE: This function is safe for concurrent access.
+func (s *SigCache) Stats() SigCacheStats {
+	s.RLock()
+	entries := uint(len(s.validSigs))
+	s.RUnlock()
+
+	return SigCacheStats{
+		Entries:    entries,
+		MaxEntries: s.maxEntries,
+		Hits:       atomic.LoadUint64(&s.hits),
+		Misses:     atomic.LoadUint64(&s.misses),
+		Evictions:  atomic.LoadUint64(&s.evictions),
+	}
+}
@@ -211,6 +211,16 @@ func (s *stack) DropN(n int32) error {
 	return nil
 }
 
+// reset removes all items from the stack and clears the minimal data flag
+// while keeping the underlying storage for reuse.
+func (s *stack) reset() {
+	for i := range s.stk {
+		s.stk[i] = nil
+	}
+	s.stk = s.stk[:0]
+	s.verifyMinimalData = false
+}
+
 // DupN duplicates the top N items on the stack.
 //
 // Stack transformation:
@@ -11,6 +11,7 @@ require (
 	github.com/golang-collections/collections v0.0.0-20130729185459-604e922904d3
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/jrick/logrotate v1.0.0
+	github.com/klauspost/compress v1.11.7
 	github.com/magiconair/properties v1.8.1
 	github.com/mattn/go-colorable v0.1.1
 	github.com/pkg/errors v0.8.1
@@ -215,6 +215,12 @@ type Params struct {
 	MinerConfirmationWindow       uint32
 	Deployments                   map[uint32][]ConsensusDeployment
 
+	// ScriptCSVHeight and ScriptSHA256Height are the heights from which
+	// OP_CHECKSEQUENCEVERIFY and OP_SHA256 are enforced by scripts. Zero
+	// enforces them from genesis.
+	ScriptCSVHeight    uint64
+	ScriptSHA256Height uint64
+
 	// Mempool parameters
 	RelayNonStdTxs bool
 